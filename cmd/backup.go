@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"chrono/pkg/backup"
+	"chrono/pkg/chrono"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backup related operations",
+	Long:  ``,
+}
+
+var backupPushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Pushes the history of all sessions to the backup remote now",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = backup.PushNow()
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Backed up successfully")
+		return nil
+	},
+}
+
+var backupStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the outcome of the last backups",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		cfg, configured := backup.Configured()
+
+		status, err := backup.ReadStatus()
+		if errors.Is(err, os.ErrNotExist) {
+			if !configured {
+				return backup.ErrNotConfigured
+			}
+			fmt.Printf("Remote:       %v\n", cfg.Remote)
+			fmt.Println("No backup was made yet")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		red := color.New(color.FgRed).SprintFunc()
+		green := color.New(color.FgGreen).SprintFunc()
+
+		never := func(t string, zero bool) string {
+			if zero {
+				return "never"
+			}
+			return t
+		}
+
+		fmt.Printf("Remote:       %v\n", status.Remote)
+		fmt.Printf("Last attempt: %v\n", never(status.LastAttempt.Format("15:04:05 02/01/2006"), status.LastAttempt.IsZero()))
+		fmt.Printf("Last success: %v\n", never(status.LastSuccess.Format("15:04:05 02/01/2006"), status.LastSuccess.IsZero()))
+
+		if status.LastError != "" {
+			fmt.Printf("Last error:   %v (%v failed attempts)\n", red(status.LastError), status.Failures)
+		} else {
+			fmt.Printf("State:        %v\n", green("ok"))
+		}
+
+		if len(status.Refs) > 0 {
+			fmt.Printf("References:   %v\n", strings.Join(status.Refs, ", "))
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/session"
+	"chrono/pkg/config"
+	"chrono/pkg/event/event"
+	"chrono/pkg/repository"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var forceConfig bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Config related operations",
+	Long:  ``,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Shows the effective config, once every layer is merged",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		out, err := yaml.Marshal(config.Settings)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("# Loaded from, by increasing priority:")
+		for _, source := range config.Sources {
+			fmt.Printf("#   %v\n", source)
+		}
+		fmt.Print(string(out))
+
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the config, its events and message templates",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = event.Check(config.Cfg.Events, chrono.RootPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.ValidateTemplates()
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Config is valid")
+		return nil
+	},
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Writes an example config file at the root of the repository",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, err := repository.Discover(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		path, exists := config.RepositoryFile(root)
+		if exists && !forceConfig {
+			return fmt.Errorf("%v already exists, use --force to replace it", path)
+		}
+
+		err = os.WriteFile(path, []byte(config.Example), 0644)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("path", path).Msg("Config file written")
+		return nil
+	},
+}
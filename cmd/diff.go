@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/session"
+	"chrono/pkg/repository"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Width of the bars of --stat
+const statWidth = 40
+
+var diffStat bool
+var diffNameOnly bool
+var diffPaths []string
+
+var sessionDiffCmd = &cobra.Command{
+	Use:   "diff <name> [from] [to]",
+	Short: "Shows the changes between two snapshots, or between a snapshot and the working tree",
+	Long: `Shows the changes between two snapshots, designated like for "session restore" (by label, hash,
+time, or "~N" for the Nth snapshot before the last one).
+Without "to", the working tree is compared, without "from" either, the last snapshot is used.`,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		if diffStat && diffNameOnly {
+			return errors.New("--stat and --name-only can't be used together")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		s, err := session.OpenSession(args[0])
+		if err != nil {
+			return err
+		}
+
+		var from, to string
+		if len(args) > 1 {
+			from = args[1]
+		}
+		if len(args) > 2 {
+			to = args[2]
+		}
+
+		diff, err := s.Diff(from, to, diffPaths)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case diffNameOnly:
+			for _, f := range diff.Files {
+				fmt.Println(f.Path)
+			}
+		case diffStat:
+			printStat(diff)
+		default:
+			printPatch(diff.Patch)
+		}
+
+		return nil
+	},
+}
+
+func printPatch(patch string) {
+	header := color.New(color.Bold)
+	hunk := color.New(color.FgCyan)
+	added := color.New(color.FgGreen)
+	deleted := color.New(color.FgRed)
+
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "),
+			strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "),
+			strings.HasPrefix(line, "new file"), strings.HasPrefix(line, "deleted file"),
+			strings.HasPrefix(line, "rename "), strings.HasPrefix(line, "similarity "):
+			header.Print(line)
+		case strings.HasPrefix(line, "@@"):
+			hunk.Print(line)
+		case strings.HasPrefix(line, "+"):
+			added.Print(line)
+		case strings.HasPrefix(line, "-"):
+			deleted.Print(line)
+		default:
+			fmt.Print(line)
+		}
+	}
+}
+
+func printStat(diff repository.DiffResult) {
+	added := color.New(color.FgGreen).SprintFunc()
+	deleted := color.New(color.FgRed).SprintFunc()
+
+	width, max := 0, 0
+	insertions, deletions := 0, 0
+	for _, f := range diff.Files {
+		name := statName(f)
+		if len(name) > width {
+			width = len(name)
+		}
+		if f.Insertions+f.Deletions > max {
+			max = f.Insertions + f.Deletions
+		}
+		insertions += f.Insertions
+		deletions += f.Deletions
+	}
+
+	for _, f := range diff.Files {
+		if f.Binary {
+			fmt.Printf(" %-*v | Bin\n", width, statName(f))
+			continue
+		}
+
+		plus, minus := f.Insertions, f.Deletions
+		if max > statWidth {
+			plus = (plus*statWidth + max - 1) / max
+			minus = (minus*statWidth + max - 1) / max
+		}
+
+		fmt.Printf(" %-*v | %5v %v%v\n", width, statName(f), f.Insertions+f.Deletions,
+			added(strings.Repeat("+", plus)), deleted(strings.Repeat("-", minus)))
+	}
+
+	fmt.Printf(" %v files changed, %v insertions(+), %v deletions(-)\n", len(diff.Files), insertions, deletions)
+}
+
+func statName(f repository.FileDiff) string {
+	if f.Status == "R" && f.OldPath != f.Path {
+		return f.OldPath + " => " + f.Path
+	}
+
+	return f.Path
+}
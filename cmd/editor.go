@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const editMessageHelp = `
+# Please enter the commit message of the merge, lines starting with '#' are ignored,
+# an empty message aborts the merge.
+`
+
+// isTerminal tells whether stdin is an interactive terminal
+func isTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func editor() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := os.Getenv(env); e != "" {
+			return e
+		}
+	}
+
+	return "vi"
+}
+
+// editMessage opens the editor of the user on a message and returns the edited message
+func editMessage(message string) (string, error) {
+	f, err := os.CreateTemp("", "chrono-merge-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(message + editMessageHelp)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command("sh", "-c", editor()+` "$1"`, "sh", f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+
+	bytes, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// confirm asks a yes/no question, yes being the default
+func confirm(question string) bool {
+	fmt.Printf("%v [Y/n] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/chrono/session"
+	"chrono/pkg/repository"
+)
+
+// Exit codes, so that scripts can tell failures apart
+const (
+	ExitError           = 1
+	ExitSessionNotFound = 2
+	ExitSessionExists   = 3
+	ExitAlreadyRunning  = 4
+	ExitNotRunning      = 5
+	ExitBranchChanged   = 6
+	ExitMergeConflict   = 7
+	ExitNothingToMerge  = 8
+)
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{session.ErrSessionNotFound, ExitSessionNotFound},
+	{session.ErrSessionExists, ExitSessionExists},
+	{lock.ErrAlreadyRunning, ExitAlreadyRunning},
+	{lock.ErrNotRunning, ExitNotRunning},
+	{repository.ErrBranchChanged, ExitBranchChanged},
+	{repository.ErrMergeConflict, ExitMergeConflict},
+	{repository.ErrNothingToMerge, ExitNothingToMerge},
+}
+
+func ExitCode(err error) int {
+	for _, e := range exitCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return ExitError
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/session"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var forceHooks bool
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "Git hooks related operations",
+	Long:  ``,
+}
+
+var hookInstallCmd = &cobra.Command{
+	Use:   "install [hook...]",
+	Short: "Installs git hooks snapshotting the running sessions (pre-commit, post-checkout and pre-rebase by default)",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.InstallHooks(args, forceHooks)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Hooks installed successfully")
+		return nil
+	},
+}
+
+var hookUninstallCmd = &cobra.Command{
+	Use:   "uninstall [hook...]",
+	Short: "Removes the git hooks installed by chrono",
+	Long:  ``,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.UninstallHooks(args)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Hooks removed successfully")
+		return nil
+	},
+}
+
+var hookRunCmd = &cobra.Command{
+	Use:    "run <hook>",
+	Short:  "Called by the installed git hooks",
+	Long:   ``,
+	Hidden: true,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a hook name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		return session.RunHook(args[0])
+	},
+}
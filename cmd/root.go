@@ -15,6 +15,7 @@ import (
 
 var logFile string
 var repositoryPath string
+var configOverrides []string
 
 func setupLogger() {
 	if logFile == "" {
@@ -45,27 +46,90 @@ var rootCmd = &cobra.Command{
 	in your git repository every time an event occurs (events are customizable),
 	So that you can always rollback to a specific point in time if anything goes wrong. 
 	You can squash merge all the temporary commits into one once you are done.`,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return config.SetOverrides(configOverrides)
+	},
 }
 
 func Run() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if err != nil {
+		log.Error().Msg(err.Error())
+	}
+
+	return err
 }
 
 func init() {
 	signal.Init()
 	setupLogger()
 
-	cobra.OnInitialize(config.Load)
 	rootCmd.PersistentFlags().StringVar(&logFile, "log", "", "Log file path")
 	rootCmd.PersistentFlags().StringVarP(&repositoryPath, "repository", "r", ".", "Git repository path")
+	rootCmd.PersistentFlags().StringArrayVar(&configOverrides, "set", nil, "Override a setting of the config, like --set git.mode=shadow")
 
 	rootCmd.AddCommand(sessionCmd)
 
 	sessionCmd.AddCommand(sessionCreateCmd)
 	sessionCmd.AddCommand(sessionDeleteCmd)
+	sessionCmd.AddCommand(sessionAbandonCmd)
 	sessionCmd.AddCommand(sessionListCmd)
 	sessionCmd.AddCommand(sessionStartCmd)
 	sessionCmd.AddCommand(sessionStopCmd)
 	sessionCmd.AddCommand(sessionMergeCmd)
 	sessionCmd.AddCommand(sessionShowCmd)
+	sessionCmd.AddCommand(sessionRestoreCmd)
+	sessionCmd.AddCommand(sessionMarkCmd)
+	sessionCmd.AddCommand(sessionDiffCmd)
+	sessionCmd.AddCommand(sessionCompactCmd)
+	sessionCmd.AddCommand(sessionReloadCmd)
+
+	sessionRestoreCmd.Flags().StringSliceVar(&restorePaths, "path", nil, "Only restore those paths")
+	sessionMarkCmd.Flags().BoolVarP(&deleteMark, "delete", "d", false, "Delete the mark instead")
+	sessionMergeCmd.Flags().BoolVar(&mergePreview, "preview", false, "Show what the merge would change, without merging")
+	sessionMergeCmd.Flags().BoolVar(&mergeNoEdit, "no-edit", false, "Use the generated message without opening an editor")
+	sessionMergeCmd.Flags().BoolVar(&mergeContinue, "continue", false, "Commit a merge stopped on conflicts once they are resolved")
+	sessionMergeCmd.Flags().BoolVar(&mergeAbort, "abort", false, "Cancel a merge stopped on conflicts")
+	sessionMergeCmd.Flags().StringVar(&mergeInto, "into", "", "Merge into this branch instead of the source branch, created if missing")
+	sessionMergeCmd.Flags().BoolVar(&mergeRebase, "rebase", false, "Apply the changes of the session onto the tip of the target branch")
+	sessionMergeCmd.Flags().BoolVar(&mergeNoCheckout, "no-checkout", false, "Only create the commit on the target branch, without checking it out")
+	sessionShowCmd.Flags().StringVar(&showSince, "since", "", "Only show snapshots taken after that time")
+	sessionShowCmd.Flags().StringVar(&showUntil, "until", "", "Only show snapshots taken before that time")
+	sessionShowCmd.Flags().StringSliceVar(&showPaths, "path", nil, "Only show snapshots changing those paths")
+	sessionShowCmd.Flags().StringSliceVar(&showEvents, "event", nil, "Only show snapshots made by those events (periodic, save, ...)")
+	sessionShowCmd.Flags().IntVar(&showLimit, "limit", 0, "Show at most that many snapshots")
+	sessionShowCmd.Flags().StringVar(&showFormat, "format", "table", "Output format: table, oneline or json")
+	sessionDiffCmd.Flags().BoolVar(&diffStat, "stat", false, "Only show the number of changed lines of each file")
+	sessionDiffCmd.Flags().BoolVar(&diffNameOnly, "name-only", false, "Only show the names of the changed files")
+	sessionDiffCmd.Flags().StringSliceVar(&diffPaths, "path", nil, "Only compare those paths")
+
+	rootCmd.AddCommand(snapCmd)
+
+	snapCmd.Flags().StringVarP(&snapMessage, "message", "m", "", "Snapshot message")
+	snapCmd.Flags().StringVarP(&snapLabel, "label", "l", "", "Label making the snapshot easy to find later")
+
+	rootCmd.AddCommand(hookCmd)
+
+	hookCmd.AddCommand(hookInstallCmd)
+	hookCmd.AddCommand(hookUninstallCmd)
+	hookCmd.AddCommand(hookRunCmd)
+
+	hookInstallCmd.Flags().BoolVar(&forceHooks, "force", false, "Replace existing hooks")
+
+	rootCmd.AddCommand(backupCmd)
+
+	backupCmd.AddCommand(backupPushCmd)
+	backupCmd.AddCommand(backupStatusCmd)
+
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configInitCmd)
+
+	configInitCmd.Flags().BoolVar(&forceConfig, "force", false, "Replace the existing config file")
 }
@@ -3,7 +3,12 @@ package cmd
 import (
 	"chrono/pkg/chrono"
 	"chrono/pkg/chrono/session"
+	"chrono/pkg/repository"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/rodaine/table"
@@ -12,6 +17,24 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var restorePaths []string
+
+var mergePreview bool
+var mergeNoEdit bool
+var mergeContinue bool
+var mergeAbort bool
+var mergeInto string
+var mergeRebase bool
+var mergeNoCheckout bool
+
+var showSince string
+var showUntil string
+var showPaths []string
+var showEvents []string
+var showLimit int
+var showFormat string
+var deleteMark bool
+
 var sessionCmd = &cobra.Command{
 	Use:   "session",
 	Short: "Session related operations",
@@ -31,21 +54,77 @@ var sessionCreateCmd = &cobra.Command{
 		return nil
 	},
 
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		session.CreateSession(args[0])
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.CreateSession(args[0])
+		if err != nil {
+			return err
+		}
+
 		log.Info().Str("session", args[0]).Msg("Session created successfully")
+		return nil
 	},
 }
 
 var sessionDeleteCmd = &cobra.Command{
-	Use:   "delete",
+	Use:   "delete <name>",
 	Short: "Deletes a session",
 	Long:  ``,
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		session.DeleteSession(args[0])
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.DeleteSession(args[0])
+		if err != nil {
+			return err
+		}
+
 		log.Info().Str("session", args[0]).Msg("Session deleted successfully")
+		return nil
+	},
+}
+
+var sessionAbandonCmd = &cobra.Command{
+	Use:   "abandon <name>",
+	Short: "Marks a session as abandoned, without deleting its history",
+	Long:  ``,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.Abandon(args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Session abandoned")
+		return nil
 	},
 }
 
@@ -53,11 +132,18 @@ var sessionListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "Lists existing sessions",
 	Long:  ``,
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		sessions := session.GetSessions()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
 
-		tbl := table.New("N°", "Session name", "Chrono branch", "Source Branch")
+		sessions, err := session.GetSessions()
+		if err != nil {
+			return err
+		}
+
+		tbl := table.New("N°", "Session name", "Chrono branch", "Source Branch", "Status", "Created")
 
 		tbl.WithHeaderFormatter(color.New(color.FgBlue, color.Underline, color.Bold).SprintfFunc())
 		tbl.WithFirstColumnFormatter(color.New(color.FgYellow, color.Bold).SprintfFunc())
@@ -65,11 +151,22 @@ var sessionListCmd = &cobra.Command{
 
 		i := 1
 		for _, session := range sessions {
-			tbl.AddRow(i, session.Name, session.Branch, session.Source)
+			branch := session.Branch
+			if session.Shadow() {
+				branch = session.RefName()
+			}
+
+			created := ""
+			if !session.CreatedAt.IsZero() {
+				created = session.CreatedAt.Format("15:04:05 02/01/2006")
+			}
+
+			tbl.AddRow(i, session.Name, branch, session.Source, session.Status, created)
 			i++
 		}
 
 		tbl.Print()
+		return nil
 	},
 }
 
@@ -86,71 +183,482 @@ var sessionStartCmd = &cobra.Command{
 		return nil
 	},
 
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		s := session.OpenSession(args[0])
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		s, err := session.OpenSession(args[0])
+		if err != nil {
+			return err
+		}
+
 		log.Info().Str("session", args[0]).Msg("Session opened")
-		s.Start()
+		return s.Start()
 	},
 }
 
 var sessionMergeCmd = &cobra.Command{
-	Use:   "merge",
+	Use:   "merge <name> [message]",
 	Short: "To squash merge all session commits to the original branch",
-	Long:  ``,
+	Long: `Squash merges all session commits to the original branch.
+Without a message, a message summarizing the session is generated and opened in $EDITOR (unless --no-edit is given).
+On conflicts, the merge is left in progress: resolve the conflicts, stage the files with "git add",
+then run "session merge --continue", or "session merge --abort" to give up.
+With --into, the session is merged into another branch, created from the base of the session if missing.
+When the target branch advanced since the session was created, --rebase applies the changes of the session
+onto its new tip instead (you are asked when running in a terminal), --no-checkout only creates
+the commit on the target branch, leaving the working tree alone.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if mergeContinue && mergeAbort {
+			return errors.New("--continue and --abort can't be used together")
+		}
+
+		if len(args) < 1 && !mergeContinue && !mergeAbort {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		if mergeContinue {
+			name, err := session.ContinueMerge()
+			if err != nil {
+				return err
+			}
+
+			log.Info().Str("session", name).Msg("Session merged successfully")
+			return nil
+		}
+
+		if mergeAbort {
+			name, err := session.AbortMerge()
+			if err != nil {
+				return err
+			}
+
+			log.Info().Str("session", name).Msg("Merge aborted")
+			return nil
+		}
+
+		s, err := session.OpenSession(args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Session opened")
+
+		opts := session.MergeOptions{
+			Into:       mergeInto,
+			Rebase:     mergeRebase,
+			NoCheckout: mergeNoCheckout,
+		}
+
+		if mergePreview {
+			return previewMerge(s, opts)
+		}
+
+		advanced, err := s.TargetAdvanced(opts)
+		if err != nil {
+			return err
+		}
+
+		if advanced > 0 && !cmd.Flags().Changed("rebase") && !opts.NoCheckout {
+			log.Warn().Str("branch", s.Target(opts)).Int("commits", advanced).Msg("The target branch advanced since the session was created")
+
+			if isTerminal() {
+				opts.Rebase = confirm(fmt.Sprintf("Apply the changes of the session onto the new tip of %v instead of merging?", s.Target(opts)))
+			}
+		}
+
+		var message string
+		if len(args) > 1 {
+			message = args[1]
+		} else {
+			message, err = s.MergeMessage()
+			if err != nil {
+				return err
+			}
+
+			if !mergeNoEdit && isTerminal() {
+				message, err = editMessage(message)
+				if err != nil {
+					return err
+				}
+
+				if message == "" {
+					return errors.New("Empty merge message, merge aborted")
+				}
+			}
+		}
+
+		err = s.SquashMerge(message, opts)
+
+		var conflict *repository.MergeConflictError
+		if errors.As(err, &conflict) {
+			red := color.New(color.FgRed).SprintFunc()
+
+			fmt.Println("Conflicts in:")
+			for _, f := range conflict.Files {
+				fmt.Println("    " + red(f))
+			}
+			fmt.Println(`Resolve them, stage them with "git add", then run "chrono session merge --continue" (or --abort)`)
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Session merged successfully")
+		return nil
+	},
+}
+
+func previewMerge(s *session.Session, opts session.MergeOptions) error {
+	preview, err := s.PreviewMerge(opts)
+	if err != nil {
+		return err
+	}
+
+	message, err := s.MergeMessage()
+	if err != nil && !errors.Is(err, repository.ErrNothingToMerge) {
+		return err
+	}
+
+	bold := color.New(color.Bold)
+	bold.Println("Message:")
+	fmt.Println(message)
+
+	bold.Printf("Changes to %v:\n", s.Target(opts))
+	printStat(preview.Diff)
+	fmt.Println()
+	printPatch(preview.Diff.Patch)
+
+	if len(preview.Conflicts) > 0 {
+		red := color.New(color.FgRed).SprintFunc()
+
+		bold.Println("Conflicts (not part of the changes above):")
+		for _, f := range preview.Conflicts {
+			fmt.Println("    " + red(f))
+		}
+	}
+
+	return nil
+}
+
+var sessionRestoreCmd = &cobra.Command{
+	Use:   "restore <name> <label|commit|time>",
+	Short: "Restores the working tree to a session snapshot",
+	Long: `Restores the working tree to a session snapshot, designated either by a label, by its hash
+(or a prefix of it, as shown by "session show") or by a time like "10 minutes ago", "14:05"
+or "2022-09-01 14:05", in which case the last snapshot before that time is used.
+The current state is committed before restoring, so a restore can itself be undone.`,
+
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("Please specify a session name")
 		} else if len(args) < 2 {
-			return errors.New("Please provide a commit message")
+			return errors.New("Please specify a label, a commit or a time")
 		}
 
 		return nil
 	},
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		s := session.OpenSession(args[0])
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		s, err := session.OpenSession(args[0])
+		if err != nil {
+			return err
+		}
+
 		log.Info().Str("session", args[0]).Msg("Session opened")
-		s.SquashMerge(args[1])
+		err = s.Restore(args[1], restorePaths)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Restored successfully")
+		return nil
 	},
 }
 
 var sessionStopCmd = &cobra.Command{
-	Use:   "stop",
-	Short: "Stops the session",
+	Use:   "stop <name>",
+	Short: "Stops a running session",
 	Long:  ``,
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Fatal().Msg("Not implemented yet")
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.Stop(args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Session stopped")
+		return nil
 	},
 }
 
 var sessionShowCmd = &cobra.Command{
-	Use:   "show",
+	Use:   "show <name>",
 	Short: "Shows git commits specific to a session",
-	Long:  ``,
+	Long: `Shows git commits specific to a session, most recent first.
+--since and --until accept times like "session restore" does, --path accepts globs or directories.`,
 
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 1 {
 			return errors.New("Please specify a session name")
 		}
 
+		switch showFormat {
+		case "table", "oneline", "json":
+		default:
+			return fmt.Errorf("unknown format %q, use table, oneline or json", showFormat)
+		}
+
 		return nil
 	},
 
-	Run: func(cmd *cobra.Command, args []string) {
-		chrono.Init(repositoryPath)
-		commits := session.GetSessionCommits(args[0])
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
 
-		tbl := table.New("Hash", "Author", "Message")
+		filter := session.Filter{
+			Paths:  showPaths,
+			Events: showEvents,
+			Limit:  showLimit,
+		}
 
-		tbl.WithHeaderFormatter(color.New(color.FgBlue, color.Underline, color.Bold).SprintfFunc())
-		tbl.WithFirstColumnFormatter(color.New(color.FgYellow, color.Bold).SprintfFunc())
-		tbl.WithPadding(8)
+		if showSince != "" {
+			filter.Since, err = session.ParseTime(showSince)
+			if err != nil {
+				return err
+			}
+		}
 
-		for _, c := range commits {
-			tbl.AddRow(c.Hash[:8], c.Author, c.Message)
+		if showUntil != "" {
+			filter.Until, err = session.ParseTime(showUntil)
+			if err != nil {
+				return err
+			}
 		}
 
-		tbl.Print()
+		commits, err := session.GetSessionCommits(args[0])
+		if err != nil {
+			return err
+		}
+
+		labels, err := session.GetSessionLabels(args[0], commits)
+		if err != nil {
+			return err
+		}
+
+		commits, err = session.FilterCommits(commits, filter)
+		if err != nil {
+			return err
+		}
+
+		switch showFormat {
+		case "json":
+			return printCommitsJSON(commits, labels)
+		case "oneline":
+			printCommitsOneline(commits, labels)
+		default:
+			printCommitsTable(commits, labels)
+		}
+
+		return nil
+	},
+}
+
+type shownCommit struct {
+	repository.CommitInfo
+	Labels []string `json:"Labels"`
+}
+
+func printCommitsJSON(commits []repository.CommitInfo, labels map[string][]string) error {
+	shown := make([]shownCommit, 0, len(commits))
+	for _, c := range commits {
+		l := labels[c.Hash]
+		if l == nil {
+			l = []string{}
+		}
+		shown = append(shown, shownCommit{CommitInfo: c, Labels: l})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(shown)
+}
+
+func printCommitsOneline(commits []repository.CommitInfo, labels map[string][]string) {
+	hash := color.New(color.FgYellow).SprintFunc()
+	label := color.New(color.FgGreen, color.Bold).SprintFunc()
+
+	for _, c := range commits {
+		line := fmt.Sprintf("%v %v", hash(c.Hash[:8]), c.When.Format("15:04:05 02/01/2006"))
+		if l := labels[c.Hash]; len(l) > 0 {
+			line += " " + label("("+strings.Join(l, ", ")+")")
+		}
+
+		fmt.Println(line, session.Summary(c.Message))
+	}
+}
+
+func printCommitsTable(commits []repository.CommitInfo, labels map[string][]string) {
+	tbl := table.New("Hash", "Time", "Event", "Label", "Files", "+/-", "Message")
+
+	tbl.WithHeaderFormatter(color.New(color.FgBlue, color.Underline, color.Bold).SprintfFunc())
+	tbl.WithFirstColumnFormatter(color.New(color.FgYellow, color.Bold).SprintfFunc())
+	tbl.WithPadding(4)
+
+	for _, c := range commits {
+		tbl.AddRow(
+			c.Hash[:8],
+			c.When.Format("15:04:05 02/01/2006"),
+			c.Author,
+			strings.Join(labels[c.Hash], ", "),
+			len(c.Files),
+			fmt.Sprintf("+%v -%v", c.Insertions, c.Deletions),
+			session.Summary(c.Message),
+		)
+	}
+
+	tbl.Print()
+}
+
+var sessionMarkCmd = &cobra.Command{
+	Use:   "mark <name> <label> [label|commit|time]",
+	Short: "Gives a label to a session snapshot",
+	Long: `Gives a label to a session snapshot (the last one by default), which can then be used
+in place of its hash, the snapshot is designated like for "session restore".`,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		} else if len(args) < 2 {
+			return errors.New("Please specify a label")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		s, err := session.OpenSession(args[0])
+		if err != nil {
+			return err
+		}
+
+		if deleteMark {
+			err = s.Unmark(args[1])
+			if err != nil {
+				return err
+			}
+
+			log.Info().Str("session", args[0]).Str("label", args[1]).Msg("Mark deleted successfully")
+			return nil
+		}
+
+		ref := ""
+		if len(args) > 2 {
+			ref = args[2]
+		}
+
+		c, err := s.Mark(args[1], ref)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Str("label", args[1]).Str("hash", c.Hash[:8]).Msg("Marked successfully")
+		return nil
+	},
+}
+
+var sessionCompactCmd = &cobra.Command{
+	Use:   "compact <name>",
+	Short: "Thins the snapshots of a session out according to the retention policy",
+	Long: `Thins the snapshots of a session out according to the retention policy of the config,
+labeled snapshots and the last one are always kept, so the final state of the session doesn't change.
+The session branch is rewritten, by the running session process if there is one.`,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		msg, err := session.Compact(args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg(msg)
+		return nil
+	},
+}
+
+var sessionReloadCmd = &cobra.Command{
+	Use:   "reload <name>",
+	Short: "Makes a running session apply the current config",
+	Long: `Makes a running session apply the current config, only the events whose config changed are restarted.
+Running sessions also reload their config when its files change, or when they receive SIGHUP.`,
+
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Please specify a session name")
+		}
+
+		return nil
+	},
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		err = session.ReloadConfig(args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", args[0]).Msg("Config reloaded")
+		return nil
 	},
 }
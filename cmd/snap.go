@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/session"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var snapMessage string
+var snapLabel string
+
+var snapCmd = &cobra.Command{
+	Use:   "snap [session]",
+	Short: "Takes a snapshot right now",
+	Long: `Takes a snapshot of the whole working tree right now, the running session commits it,
+or it is committed directly if the session isn't running.
+The session can be omitted when only one is running, or when there is only one active session.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := chrono.Init(repositoryPath)
+		if err != nil {
+			return err
+		}
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		} else {
+			name, err = session.DefaultSession()
+			if err != nil {
+				return err
+			}
+		}
+
+		err = session.Snap(name, snapMessage, snapLabel)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("session", name).Msg("Snapshot taken successfully")
+		return nil
+	},
+}
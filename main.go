@@ -1,9 +1,14 @@
 package main
 
 import (
+	"os"
+
 	"chrono/cmd"
 )
 
 func main() {
-	cmd.Run()
+	err := cmd.Run()
+	if err != nil {
+		os.Exit(cmd.ExitCode(err))
+	}
 }
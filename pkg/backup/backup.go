@@ -0,0 +1,286 @@
+package backup
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/config"
+	"chrono/pkg/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StatusFileName records the outcome of the last backups
+const StatusFileName = "backup.json"
+
+const (
+	defaultRetries = 5
+	defaultBackoff = 10 * time.Second
+	maxBackoff     = 10 * time.Minute
+)
+
+// The references owned by Chrono
+var refPrefixes = []string{
+	"refs/heads/chrono/",
+	chrono.ShadowRefPrefix,
+	"refs/chrono-marks/",
+}
+
+var ErrNotConfigured = errors.New("no backup remote configured")
+
+type Status struct {
+	Remote      string    `json:"Remote"`
+	LastAttempt time.Time `json:"LastAttempt"`
+	LastSuccess time.Time `json:"LastSuccess,omitempty"`
+	LastError   string    `json:"LastError,omitempty"`
+
+	// Consecutive failed attempts
+	Failures int `json:"Failures"`
+
+	// References pushed by the last successful backup
+	Refs []string `json:"Refs,omitempty"`
+}
+
+// Backup pushes the references of Chrono to the configured remote
+type Backup struct {
+	cfg config.CfgBackup
+	r   *repository.Repository
+
+	trigger   chan struct{}
+	snapshots int
+	mutex     sync.Mutex
+}
+
+// Configured returns the backup config, or false if backups are disabled
+func Configured() (config.CfgBackup, bool) {
+	if config.Cfg.Backup == nil || config.Cfg.Backup.Remote == "" {
+		return config.CfgBackup{}, false
+	}
+
+	cfg := *config.Cfg.Backup
+	if cfg.Retries <= 0 {
+		cfg.Retries = defaultRetries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+
+	return cfg, true
+}
+
+func New(r *repository.Repository, cfg config.CfgBackup) *Backup {
+	return &Backup{
+		cfg:     cfg,
+		r:       r,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+func statusPath() string {
+	return filepath.Join(chrono.RootPath, chrono.DotChronoDirName, StatusFileName)
+}
+
+func ReadStatus() (Status, error) {
+	var status Status
+
+	bytes, err := os.ReadFile(statusPath())
+	if err != nil {
+		return status, err
+	}
+
+	err = json.Unmarshal(bytes, &status)
+	return status, err
+}
+
+func writeStatus(status Status) error {
+	bytes, err := json.MarshalIndent(&status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := statusPath() + ".tmp"
+	err = os.WriteFile(tmp, bytes, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, statusPath())
+}
+
+// remote returns where to push, local mirrors being created if needed
+func (b *Backup) remote() (string, error) {
+	path, local := repository.LocalPath(b.cfg.Remote)
+	if !local {
+		return b.cfg.Remote, nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(chrono.RootPath, path)
+	}
+
+	return path, repository.InitMirror(path)
+}
+
+// Push backs the references of Chrono up once, and records the outcome
+func (b *Backup) Push() error {
+	status, _ := ReadStatus()
+	status.Remote = b.cfg.Remote
+	status.LastAttempt = time.Now()
+
+	refs, err := b.push()
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+	} else {
+		status.Failures = 0
+		status.LastError = ""
+		status.LastSuccess = status.LastAttempt
+		status.Refs = refs
+	}
+
+	if writeErr := writeStatus(status); writeErr != nil {
+		log.Warn().Err(writeErr).Msg("Couldn't record backup status")
+	}
+
+	return err
+}
+
+func (b *Backup) push() ([]string, error) {
+	remote, err := b.remote()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := []string{}
+	for _, prefix := range refPrefixes {
+		found, err := b.r.ListRefs(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range found {
+			refs = append(refs, name)
+		}
+	}
+	sort.Strings(refs)
+
+	if len(refs) == 0 {
+		return refs, nil
+	}
+
+	err = b.r.Push(remote, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("remote", b.cfg.Remote).Int("refs", len(refs)).Msg("Backed up")
+	return refs, nil
+}
+
+// Snapshotted counts a new snapshot, and triggers a backup once enough of them were made
+func (b *Backup) Snapshotted() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.snapshots++
+	if b.cfg.After > 0 && b.snapshots >= b.cfg.After {
+		select {
+		case b.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run backs up on schedule and when triggered until ctx is done, failed pushes are retried
+// with an exponential backoff, a last backup is attempted when stopping if there are new snapshots
+func (b *Backup) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if b.cfg.Every > 0 {
+		ticker := time.NewTicker(b.cfg.Every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var retry <-chan time.Time
+	failures := 0
+
+	attempt := func() {
+		b.mutex.Lock()
+		pending := b.snapshots
+		b.snapshots = 0
+		b.mutex.Unlock()
+
+		err := b.Push()
+		if err == nil {
+			failures = 0
+			retry = nil
+			return
+		}
+
+		b.mutex.Lock()
+		b.snapshots += pending
+		b.mutex.Unlock()
+
+		failures++
+		if failures > b.cfg.Retries {
+			log.Error().Err(err).Int("attempts", failures).Msg("Backup failed, giving up until the next one")
+			failures = 0
+			retry = nil
+			return
+		}
+
+		delay := b.cfg.Backoff << (failures - 1)
+		if delay > maxBackoff || delay <= 0 {
+			delay = maxBackoff
+		}
+
+		log.Warn().Err(err).Dur("retry-in", delay).Msg("Backup failed")
+		retry = time.After(delay)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.mutex.Lock()
+			pending := b.snapshots
+			b.mutex.Unlock()
+
+			if pending > 0 {
+				if err := b.Push(); err != nil {
+					log.Error().Err(err).Msg("Last backup failed")
+				}
+			}
+			return
+
+		case <-tick:
+			attempt()
+
+		case <-b.trigger:
+			attempt()
+
+		case <-retry:
+			attempt()
+		}
+	}
+}
+
+// PushNow backs the references of Chrono up once, outside of any session
+func PushNow() error {
+	cfg, ok := Configured()
+	if !ok {
+		return ErrNotConfigured
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return err
+	}
+
+	return New(r, cfg).Push()
+}
@@ -1,40 +1,56 @@
 package chrono
 
 import (
+	"chrono/pkg/config"
+	"chrono/pkg/repository"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-
-	"github.com/rs/zerolog/log"
 )
 
 const DotChronoDirName string = ".chrono"
 const SessionsFileName string = "sessions.json"
+const RunDirName string = "run"
 
+// RootPath is the root of the working tree, in a linked worktree it is the root of that worktree,
+// which then has its own .chrono directory
 var RootPath string
 
-func Init(path string) {
-	RootPath = path
+func Init(path string) error {
+	root, err := repository.Discover(path)
+	if err != nil {
+		return err
+	}
+
+	RootPath = root
 
-	cp := filepath.Join(path, DotChronoDirName)
-	err := os.MkdirAll(cp, os.ModePerm)
+	err = config.Load(root)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create .chrono directory")
+		return err
 	}
 
-	sp := filepath.Join(path, DotChronoDirName, SessionsFileName)
+	cp := filepath.Join(root, DotChronoDirName)
+	err = os.MkdirAll(cp, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create .chrono directory: %w", err)
+	}
 
-	_, err = os.ReadFile(sp)
-	if os.IsNotExist(err) {
-		f, err := os.Create(sp)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to create sessions file")
-		}
+	// Chrono's own files must never end up in commits, nor be changed by checkouts
+	err = os.WriteFile(filepath.Join(cp, ".gitignore"), []byte("*\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write .chrono/.gitignore: %w", err)
+	}
 
-		_, err = f.WriteString("{}")
+	_, err = os.Stat(sessionsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		err = UpdateSessions(func(sessions map[string]SessionDef) error {
+			return nil
+		})
 		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to write to sessions file")
+			return fmt.Errorf("failed to create sessions file: %w", err)
 		}
-
-		f.Close()
 	}
+
+	return nil
 }
@@ -0,0 +1,175 @@
+package lock
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"chrono/pkg/chrono"
+)
+
+// Unix socket paths are limited to around 108 bytes, longer ones are moved to the temp directory
+const maxSocketPathLen = 100
+
+var ErrAlreadyRunning = errors.New("session is already running")
+var ErrNotRunning = errors.New("session is not running")
+
+type Lock struct {
+	PID       int       `json:"PID"`
+	Session   string    `json:"Session"`
+	StartedAt time.Time `json:"StartedAt"`
+	Socket    string    `json:"Socket"`
+
+	path string
+}
+
+func runDir(root string) string {
+	return filepath.Join(root, chrono.DotChronoDirName, chrono.RunDirName)
+}
+
+func fileName(session string) string {
+	return strings.ReplaceAll(session, string(filepath.Separator), "_")
+}
+
+func lockPath(root string, session string) string {
+	return filepath.Join(runDir(root), fileName(session)+".lock")
+}
+
+func socketPath(root string, session string) string {
+	p, err := filepath.Abs(filepath.Join(runDir(root), fileName(session)+".sock"))
+	if err == nil && len(p) <= maxSocketPathLen {
+		return p
+	}
+
+	sum := sha1.Sum([]byte(p))
+	return filepath.Join(os.TempDir(), "chrono-"+hex.EncodeToString(sum[:8])+".sock")
+}
+
+// Acquire creates the lock file of a session, cleaning up a stale lock left by a dead process if needed
+func Acquire(root string, session string) (*Lock, error) {
+	err := os.MkdirAll(runDir(root), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{
+		PID:       os.Getpid(),
+		Session:   session,
+		StartedAt: time.Now(),
+		Socket:    socketPath(root, session),
+		path:      lockPath(root, session),
+	}
+
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is written aside then linked into place, so that it is never seen half written
+	tmp, err := os.CreateTemp(runDir(root), fileName(session)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(bytes)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err = os.Link(tmp.Name(), l.path)
+		if errors.Is(err, os.ErrExist) {
+			existing, err := Read(root, session)
+			if err == nil {
+				return nil, fmt.Errorf("%w (pid %v)", ErrAlreadyRunning, existing.PID)
+			}
+			if !errors.Is(err, ErrNotRunning) {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		os.Remove(l.Socket)
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("couldn't acquire lock %v", l.path)
+}
+
+// Read returns the lock of a running session, a stale lock is removed and ErrNotRunning is returned
+func Read(root string, session string) (*Lock, error) {
+	p := lockPath(root, session)
+
+	bytes, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A lock is always complete, one that can't be parsed wasn't written by chrono and is left alone
+	l := &Lock{path: p}
+	err = json.Unmarshal(bytes, l)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock file %v, remove it if the session isn't running: %w", p, err)
+	}
+
+	if !alive(l.PID) {
+		l.Release()
+		return nil, ErrNotRunning
+	}
+
+	return l, nil
+}
+
+// IsStale reports whether a lock file exists for a session whose process is gone, without removing it
+func IsStale(root string, session string) bool {
+	bytes, err := os.ReadFile(lockPath(root, session))
+	if err != nil {
+		return false
+	}
+
+	var l Lock
+	if json.Unmarshal(bytes, &l) != nil {
+		return false
+	}
+
+	return !alive(l.PID)
+}
+
+func (l *Lock) Release() error {
+	if l.Socket != "" {
+		os.Remove(l.Socket)
+	}
+
+	err := os.Remove(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,43 @@
+package session
+
+import (
+	"chrono/pkg/repository"
+	"fmt"
+)
+
+// Diff compares two snapshots designated like for restore, from defaults to the last snapshot
+// and to to the working tree
+func (s *Session) Diff(from string, to string, paths []string) (repository.DiffResult, error) {
+	commits, err := sessionCommits(s.r, s.Info)
+	if err != nil {
+		return repository.DiffResult{}, err
+	}
+
+	if len(commits) == 0 {
+		return repository.DiffResult{}, fmt.Errorf("session %v has no snapshot yet", s.Info.Name)
+	}
+
+	labels, err := s.labels(commits)
+	if err != nil {
+		return repository.DiffResult{}, err
+	}
+
+	old := commits[0]
+	if from != "" {
+		old, err = ResolveSnapshot(commits, labels, from)
+		if err != nil {
+			return repository.DiffResult{}, err
+		}
+	}
+
+	newHash := ""
+	if to != "" {
+		c, err := ResolveSnapshot(commits, labels, to)
+		if err != nil {
+			return repository.DiffResult{}, err
+		}
+		newHash = c.Hash
+	}
+
+	return s.r.Diff(old.Hash, newHash, paths)
+}
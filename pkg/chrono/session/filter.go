@@ -0,0 +1,104 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/repository"
+	"path"
+	"strings"
+	"time"
+)
+
+// Filter selects the snapshots shown by `session show`, zero values don't filter anything
+type Filter struct {
+	Since time.Time
+	Until time.Time
+
+	// Globs, or directories, the snapshots must have changed
+	Paths []string
+
+	// Events which made the snapshots, like "periodic" or "save"
+	Events []string
+
+	Limit int
+}
+
+// ParseTime parses a time like the ones designating snapshots, "10 minutes ago" or "14:05" for instance
+func ParseTime(s string) (time.Time, error) {
+	return parseTime(strings.TrimSpace(s), time.Now())
+}
+
+// FilterCommits returns the commits matching the filter with their stats loaded, stats are only
+// computed for the commits that may be shown
+func FilterCommits(commits []repository.CommitInfo, f Filter) ([]repository.CommitInfo, error) {
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []repository.CommitInfo{}
+
+	for _, c := range commits {
+		if f.Limit > 0 && len(filtered) >= f.Limit {
+			break
+		}
+
+		if !f.Since.IsZero() && c.When.Before(f.Since) {
+			continue
+		}
+
+		if !f.Until.IsZero() && c.When.After(f.Until) {
+			continue
+		}
+
+		if len(f.Events) > 0 && !matchEvent(c, f.Events) {
+			continue
+		}
+
+		one := []repository.CommitInfo{c}
+		err = r.LoadStats(one)
+		if err != nil {
+			return nil, err
+		}
+		c = one[0]
+
+		if len(f.Paths) > 0 && !matchPaths(c, f.Paths) {
+			continue
+		}
+
+		filtered = append(filtered, c)
+	}
+
+	return filtered, nil
+}
+
+func matchEvent(c repository.CommitInfo, events []string) bool {
+	for _, e := range events {
+		if strings.EqualFold(c.Author, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPaths(c repository.CommitInfo, globs []string) bool {
+	for _, glob := range globs {
+		glob = strings.TrimPrefix(path.Clean(glob), "./")
+
+		for _, file := range c.Files {
+			if glob == "." || strings.HasPrefix(file, glob+"/") {
+				return true
+			}
+
+			if ok, _ := path.Match(glob, file); ok {
+				return true
+			}
+
+			if ok, _ := path.Match(glob, path.Base(file)); ok && !strings.Contains(glob, "/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/config"
+	"chrono/pkg/repository"
+	"errors"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Sub-sessions created when following a branch are named <session>@<branch>
+const subSessionSeparator string = "@"
+
+func branchChangePolicy() string {
+	if config.Cfg.Git == nil || config.Cfg.Git.OnBranchChange == "" {
+		return config.PolicyFatal
+	}
+
+	return config.Cfg.Git.OnBranchChange
+}
+
+// guard applies the on-branch-change policy before each commit
+func (s *Session) guard() (bool, error) {
+	err := s.r.AssertBranchNotChanged()
+
+	var changed *repository.BranchChangedError
+	if !errors.As(err, &changed) {
+		if err == nil && s.paused {
+			log.Info().Msg("Session branch is back, resuming")
+			s.paused = false
+		}
+
+		return err == nil, err
+	}
+
+	policy := branchChangePolicy()
+
+	// Shadow sessions never touch the branch, so they can keep committing unless asked to follow
+	if s.current.Shadow() && policy != config.PolicyFollow {
+		return true, nil
+	}
+
+	switch policy {
+	case config.PolicyPause:
+		s.pause(changed)
+		return false, nil
+
+	case config.PolicyFollow:
+		if changed.Found == "" {
+			s.pause(changed)
+			return false, nil
+		}
+
+		err = s.follow(changed.Found)
+		return err == nil, err
+
+	default:
+		return false, err
+	}
+}
+
+func (s *Session) pause(changed *repository.BranchChangedError) {
+	if s.paused {
+		return
+	}
+
+	log.Warn().Str("expected", changed.Expected).
+		Str("found", changed.Found).
+		Msg("Branch changed, pausing until the session branch is checked out again")
+	s.paused = true
+}
+
+// follow switches to the sub-session of the branch that got checked out, creating it if needed
+func (s *Session) follow(branch string) error {
+	root := s.Info.Name
+	if s.Info.Parent != "" {
+		root = s.Info.Parent
+	}
+
+	var target *SessionDef
+	err := chrono.UpdateSessions(func(sessions map[string]SessionDef) error {
+		for _, def := range sessions {
+			if def.Name != root && def.Parent != root {
+				continue
+			}
+
+			// Either the session branch itself was checked out, or the branch a sub-session follows
+			if def.Branch == branch || (def.Parent == root && def.Source == branch) {
+				d := def
+				target = &d
+				return nil
+			}
+		}
+
+		name := root + subSessionSeparator + branch
+
+		def, err := createSessionRef(s.r, name, branch, s.Info.Shadow())
+		if err != nil {
+			return err
+		}
+		def.Parent = root
+
+		sessions[name] = def
+		target = &def
+
+		log.Info().Str("session", name).Msg("Created sub-session for the new branch")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// HEAD stays where the user put it, sub-sessions are committed to like shadow sessions
+	if target.Branch == branch && !target.Shadow() {
+		s.r.FollowBranch(branch)
+	} else {
+		err = s.r.UseShadowRef(target.RefName())
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("session", target.Name).Str("branch", branch).Msg("Following branch")
+	s.current = *target
+	s.paused = false
+	return nil
+}
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/control"
+	"chrono/pkg/event/hook"
+	"chrono/pkg/repository"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Installed hooks contain this marker, hooks without it are never overwritten nor removed
+const hookMarker = "# Installed by chrono"
+
+var ErrHookExists = errors.New("hook already exists")
+
+func hookScript(executable string, name string) string {
+	return fmt.Sprintf(`#!/bin/sh
+%v, snapshots the running sessions before git goes on, whatever happens
+%q hook run %v || echo "chrono: the sessions weren't snapshotted before %v" >&2
+`, hookMarker, executable, name, name)
+}
+
+func isChronoHook(path string) bool {
+	bytes, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(bytes), hookMarker)
+}
+
+// InstallHooks writes the given git hooks (all supported ones if empty) calling chrono,
+// existing hooks which weren't installed by chrono are only replaced when force is set
+func InstallHooks(names []string, force bool) error {
+	if len(names) == 0 {
+		names = hook.Supported
+	}
+
+	for _, name := range names {
+		if !hook.IsSupported(name) {
+			return fmt.Errorf("unsupported hook %q, supported hooks are %v", name, strings.Join(hook.Supported, ", "))
+		}
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	dir := r.HooksDir()
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		_, err := os.Stat(path)
+		if err == nil && !force && !isChronoHook(path) {
+			return fmt.Errorf("%w: %v, use --force to replace it", ErrHookExists, path)
+		}
+
+		err = os.WriteFile(path, []byte(hookScript(executable, name)), 0755)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("hook", path).Msg("Installed hook")
+	}
+
+	return nil
+}
+
+// UninstallHooks removes the given git hooks (all supported ones if empty) installed by chrono
+func UninstallHooks(names []string) error {
+	if len(names) == 0 {
+		names = hook.Supported
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(r.HooksDir(), name)
+		if !isChronoHook(path) {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("hook", path).Msg("Removed hook")
+	}
+
+	return nil
+}
+
+// RunHook asks every running session to snapshot because of a git hook,
+// and returns once they are done
+func RunHook(name string) error {
+	if !hook.IsSupported(name) {
+		return fmt.Errorf("unsupported hook %q", name)
+	}
+
+	sessions, err := GetSessions()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, s := range sessions {
+		l, err := lock.Read(chrono.RootPath, s.Name)
+		if err != nil {
+			continue
+		}
+
+		_, err = control.Send(l.Socket, control.Request{
+			Command: "hook",
+			Args:    map[string]string{"hook": name},
+		}, stopTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", s.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("couldn't snapshot sessions (%v)", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
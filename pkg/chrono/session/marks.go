@@ -0,0 +1,144 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/repository"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MarksRefPrefix is where marks are stored, as refs/chrono-marks/<session>/<label>, the '/' of
+// session names (sub-sessions of a/b branches for instance) being escaped as %2F
+const MarksRefPrefix = "refs/chrono-marks/"
+
+var marksEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+var ErrMarkNotFound = errors.New("mark doesn't exist")
+
+func marksPrefix(session string) string {
+	return MarksRefPrefix + marksEscaper.Replace(session) + "/"
+}
+
+// labels maps the labels of a session to their commit, both the ones given by `snap --label`
+// and the marks, a mark wins over a snapshot label of the same name
+func labels(r *repository.Repository, session string, commits []repository.CommitInfo) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	// Oldest first, so that the most recent snapshot wins when a label is reused
+	for i := len(commits) - 1; i >= 0; i-- {
+		if label := Label(commits[i].Message); label != "" {
+			labels[label] = commits[i].Hash
+		}
+	}
+
+	refs, err := r.ListRefs(marksPrefix(session))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, hash := range refs {
+		labels[strings.TrimPrefix(name, marksPrefix(session))] = hash
+	}
+
+	return labels, nil
+}
+
+// GetSessionLabels returns the labels of a session by commit hash
+func GetSessionLabels(sessionName string, commits []repository.CommitInfo) (map[string][]string, error) {
+	if _, err := GetSession(sessionName); err != nil {
+		return nil, err
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := labels(r, sessionName, commits)
+	if err != nil {
+		return nil, err
+	}
+
+	byCommit := make(map[string][]string)
+	for label, hash := range l {
+		byCommit[hash] = append(byCommit[hash], label)
+	}
+
+	for _, l := range byCommit {
+		sort.Strings(l)
+	}
+
+	return byCommit, nil
+}
+
+func (s *Session) labels(commits []repository.CommitInfo) (map[string]string, error) {
+	return labels(s.r, s.Info.Name, commits)
+}
+
+// Mark gives a label to a snapshot designated like for restore, the last one if ref is empty
+func (s *Session) Mark(label string, ref string) (repository.CommitInfo, error) {
+	err := ValidateLabel(label)
+	if err != nil {
+		return repository.CommitInfo{}, err
+	}
+
+	commits, err := sessionCommits(s.r, s.Info)
+	if err != nil {
+		return repository.CommitInfo{}, err
+	}
+
+	if len(commits) == 0 {
+		return repository.CommitInfo{}, fmt.Errorf("session %v has no snapshot yet", s.Info.Name)
+	}
+
+	target := commits[0]
+	if ref != "" {
+		l, err := s.labels(commits)
+		if err != nil {
+			return repository.CommitInfo{}, err
+		}
+
+		target, err = ResolveSnapshot(commits, l, ref)
+		if err != nil {
+			return repository.CommitInfo{}, err
+		}
+	}
+
+	err = s.r.SetRef(marksPrefix(s.Info.Name)+label, target.Hash, "chrono: marked "+label)
+	if err != nil {
+		return repository.CommitInfo{}, err
+	}
+
+	return target, nil
+}
+
+func (s *Session) Unmark(label string) error {
+	name := marksPrefix(s.Info.Name) + label
+	if !s.r.RefExists(name) {
+		return fmt.Errorf("%w: %v", ErrMarkNotFound, label)
+	}
+
+	return s.r.DeleteRef(name)
+}
+
+// deleteMarks removes every mark of a session
+func deleteMarks(r *repository.Repository, session string) error {
+	refs, err := r.ListRefs(marksPrefix(session))
+	if err != nil {
+		return err
+	}
+
+	for name := range refs {
+		err = r.DeleteRef(name)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("ref", name).Msg("Deleted mark")
+	}
+
+	return nil
+}
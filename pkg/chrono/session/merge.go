@@ -0,0 +1,334 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MergeStateFileName keeps track of a merge stopped on conflicts, until it is continued or aborted
+const MergeStateFileName = "merge.json"
+
+// How many files are listed in a generated merge message at most
+const maxMessageFiles = 20
+
+var ErrMergeInProgress = errors.New("a merge is in progress, use --continue or --abort")
+
+type MergeOptions struct {
+	// The branch to merge into instead of the source branch, created if missing
+	Into string
+
+	// Apply the changes of the session (from its base to its tip) onto the target branch
+	// with a 3-way merge, instead of merging the session branch
+	Rebase bool
+
+	// Only create the squash commit on the target branch, which isn't checked out
+	NoCheckout bool
+}
+
+// Target is the branch merged into
+func (s *Session) Target(opts MergeOptions) string {
+	if opts.Into != "" {
+		return opts.Into
+	}
+
+	return s.Info.Source
+}
+
+// TargetAdvanced counts the commits made on the target branch since the session was created
+func (s *Session) TargetAdvanced(opts MergeOptions) (int, error) {
+	target := s.Target(opts)
+	if s.Info.BaseOID == "" || !s.r.BranchExists(target) {
+		return 0, nil
+	}
+
+	return s.r.CommitsSince(s.Info.BaseOID, "refs/heads/"+target)
+}
+
+// createTarget creates the target branch at the base of the session if it doesn't exist
+func (s *Session) createTarget(target string) (bool, error) {
+	if s.r.BranchExists(target) {
+		return false, nil
+	}
+
+	if s.Info.BaseOID == "" {
+		return false, fmt.Errorf("branch %v doesn't exist, and the session has no base commit to create it from", target)
+	}
+
+	log.Info().Str("branch", target).Str("base", s.Info.BaseOID[:8]).Msg("Creating target branch")
+	return true, s.r.CreateBranchAt(target, s.Info.BaseOID)
+}
+
+// deleteTarget removes a target branch created for a merge, unless something was committed to it
+func deleteTarget(r *repository.Repository, target string, base string) {
+	count, err := r.CommitsSince(base, "refs/heads/"+target)
+	if err != nil || count > 0 {
+		return
+	}
+
+	err = r.DeleteBranch(target)
+	if err != nil {
+		log.Warn().Err(err).Str("branch", target).Msg("Couldn't delete the target branch created for the merge")
+		return
+	}
+
+	log.Info().Str("branch", target).Msg("Deleted target branch")
+}
+
+type mergeState struct {
+	Session string `json:"Session"`
+	Message string `json:"Message"`
+
+	// The branch checked out before the merge, checked out again on abort
+	Original string `json:"Original"`
+
+	// The target branch when it was created for the merge from Base, deleted again on abort
+	Created string `json:"Created,omitempty"`
+	Base    string `json:"Base,omitempty"`
+}
+
+func mergeStatePath() string {
+	return filepath.Join(chrono.RootPath, chrono.DotChronoDirName, MergeStateFileName)
+}
+
+func loadMergeState() (*mergeState, error) {
+	bytes, err := os.ReadFile(mergeStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, repository.ErrNoMergeInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &mergeState{}
+	err = json.Unmarshal(bytes, state)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted merge state %v: %w", mergeStatePath(), err)
+	}
+
+	return state, nil
+}
+
+func saveMergeState(state mergeState) error {
+	bytes, err := json.MarshalIndent(&state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(mergeStatePath(), bytes, 0644)
+}
+
+func clearMergeState() {
+	err := os.Remove(mergeStatePath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msg("Couldn't remove merge state")
+	}
+}
+
+// MergeMessage generates a message summarizing the session: how long it lasted,
+// how many snapshots it has and which files they touched
+func (s *Session) MergeMessage() (string, error) {
+	commits, files, err := s.touchedFiles()
+	if err != nil {
+		return "", err
+	}
+
+	if len(commits) == 0 {
+		return "", repository.ErrNothingToMerge
+	}
+
+	first, last := commits[len(commits)-1].When, commits[0].When
+	duration := last.Sub(first).Round(time.Minute)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Session %v: %v snapshots over %v\n\n", s.Info.Name, len(commits), duration)
+	fmt.Fprintf(&sb, "From %v to %v, %v files touched:\n",
+		first.Format("15:04:05 02/01/2006"), last.Format("15:04:05 02/01/2006"), len(files))
+
+	for i, f := range files {
+		if i == maxMessageFiles {
+			fmt.Fprintf(&sb, "- and %v more\n", len(files)-maxMessageFiles)
+			break
+		}
+		fmt.Fprintf(&sb, "- %v\n", f)
+	}
+
+	return sb.String(), nil
+}
+
+// PreviewMerge tells what merging the session would change in the target branch, without changing anything
+func (s *Session) PreviewMerge(opts MergeOptions) (repository.MergePreview, error) {
+	target := s.Target(opts)
+
+	// A missing target would be created at the base of the session
+	if !s.r.BranchExists(target) && s.Info.BaseOID != "" {
+		return s.r.PreviewMerge(s.Info.BaseOID, s.Info.RefName())
+	}
+
+	return s.r.PreviewMerge(target, s.Info.RefName())
+}
+
+// SquashMerge squashes the session into the target branch, on conflicts the merge is left
+// in progress until ContinueMerge or AbortMerge is called, unless the changes are rebased
+// or nothing is checked out, in which case nothing changes
+func (s *Session) SquashMerge(msg string, opts MergeOptions) (err error) {
+	if _, err := loadMergeState(); err == nil {
+		return ErrMergeInProgress
+	}
+
+	// A session running in branch mode would fight over the working tree
+	if _, err := lock.Read(chrono.RootPath, s.Info.Name); err == nil && !s.Info.Shadow() {
+		return fmt.Errorf("%w, stop it before merging it", lock.ErrAlreadyRunning)
+	}
+
+	message, err := s.squashMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	target := s.Target(opts)
+	created, err := s.createTarget(target)
+	if err != nil {
+		return err
+	}
+
+	// A merge stopped on conflicts still needs the branch, it is deleted on abort
+	inProgress := false
+	if created {
+		defer func() {
+			if err != nil && !inProgress {
+				deleteTarget(s.r, target, s.Info.BaseOID)
+			}
+		}()
+	}
+
+	if opts.Rebase || opts.NoCheckout {
+		err = s.squashCommit(target, message, opts)
+		if err != nil {
+			return err
+		}
+
+		return setStatus(s.Info.Name, chrono.StatusMerged)
+	}
+
+	original, err := s.r.GetBranchName()
+	if err != nil {
+		return err
+	}
+
+	err = s.r.SquashMerge(target, s.Info.RefName(), message)
+	if errors.Is(err, repository.ErrMergeConflict) {
+		state := mergeState{
+			Session:  s.Info.Name,
+			Message:  message,
+			Original: original,
+		}
+		if created {
+			state.Created, state.Base = target, s.Info.BaseOID
+		}
+
+		stateErr := saveMergeState(state)
+		if stateErr != nil {
+			return fmt.Errorf("%v, and couldn't save the merge state: %w", err, stateErr)
+		}
+		inProgress = true
+
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return setStatus(s.Info.Name, chrono.StatusMerged)
+}
+
+// squashCommit applies the changes of the session onto the target branch without a merge in the working tree
+func (s *Session) squashCommit(target string, message string, opts MergeOptions) error {
+	if s.Info.BaseOID == "" {
+		return errors.New("the session has no base commit, it can only be merged normally")
+	}
+
+	checkedOut, err := s.r.IsCheckedOut(target)
+	if err != nil {
+		return err
+	}
+
+	if opts.NoCheckout && checkedOut {
+		return fmt.Errorf("%w: %v, it can't be committed to without updating the working tree", repository.ErrBranchCheckedOut, target)
+	}
+
+	id, err := s.r.SquashCommit(target, s.Info.BaseOID, s.Info.RefName(), message)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("branch", target).Str("id", id[:8]).Msg("Squashed session")
+
+	if opts.NoCheckout {
+		return nil
+	}
+
+	if checkedOut {
+		return s.r.UpdateWorkingTree()
+	}
+
+	return s.r.CheckoutBranch(target)
+}
+
+// ContinueMerge commits a merge stopped on conflicts once they are resolved and staged, returning the merged session
+func ContinueMerge() (string, error) {
+	state, err := loadMergeState()
+	if err != nil {
+		return "", err
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.ContinueMerge(state.Message)
+	if errors.Is(err, repository.ErrNoMergeInProgress) {
+		clearMergeState()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	clearMergeState()
+	return state.Session, setStatus(state.Session, chrono.StatusMerged)
+}
+
+// AbortMerge cancels a merge stopped on conflicts, returning the session that was being merged
+func AbortMerge() (string, error) {
+	state, err := loadMergeState()
+	if err != nil {
+		return "", err
+	}
+
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.AbortMerge(state.Original)
+	if err != nil && !errors.Is(err, repository.ErrNoMergeInProgress) {
+		return "", err
+	}
+
+	if state.Created != "" {
+		deleteTarget(r, state.Created, state.Base)
+	}
+
+	clearMergeState()
+	return state.Session, nil
+}
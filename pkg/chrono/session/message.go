@@ -0,0 +1,182 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/config"
+	"chrono/pkg/repository"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The message given by the event, or to `session merge`, is used as is by default
+const defaultMessageTemplate = "{{.Message}}"
+
+// SnapshotData is what the snapshot message template is executed with
+type SnapshotData struct {
+	Session string
+	Event   string
+	Message string
+	Label   string
+	Files   []string
+	Time    time.Time
+}
+
+// SquashData is what the squash message template is executed with
+type SquashData struct {
+	Session   string
+	Source    string
+	Message   string
+	Snapshots int
+	Files     []string
+	Time      time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func messageTemplates() (snapshot string, squash string) {
+	return templatesOf(config.Cfg.Messages)
+}
+
+func templatesOf(messages *config.CfgMessages) (snapshot string, squash string) {
+	snapshot, squash = defaultMessageTemplate, defaultMessageTemplate
+
+	if messages != nil {
+		if messages.Snapshot != "" {
+			snapshot = messages.Snapshot
+		}
+		if messages.Squash != "" {
+			squash = messages.Squash
+		}
+	}
+
+	return snapshot, squash
+}
+
+// ValidateTemplates checks that the message templates of the config can be parsed
+func ValidateTemplates() error {
+	return validateTemplates(config.Cfg.Messages)
+}
+
+func validateTemplates(messages *config.CfgMessages) error {
+	snapshot, squash := templatesOf(messages)
+
+	_, err := template.New("snapshot").Funcs(templateFuncs).Parse(snapshot)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot message template: %w", err)
+	}
+
+	_, err = template.New("squash").Funcs(templateFuncs).Parse(squash)
+	if err != nil {
+		return fmt.Errorf("invalid squash message template: %w", err)
+	}
+
+	return nil
+}
+
+func render(name string, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %v message template: %w", name, err)
+	}
+
+	var sb strings.Builder
+	err = t.Execute(&sb, data)
+	if err != nil {
+		return "", fmt.Errorf("couldn't render %v message: %w", name, err)
+	}
+
+	msg := strings.TrimSpace(sb.String())
+	if msg == "" {
+		return "", fmt.Errorf("the %v message template rendered an empty message", name)
+	}
+
+	return msg, nil
+}
+
+// relFiles makes paths relative to the root of the repository, for messages
+func relFiles(paths []string) []string {
+	files := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if rel, err := filepath.Rel(chrono.RootPath, p); err == nil && filepath.IsAbs(p) {
+			p = rel
+		}
+		files = append(files, filepath.ToSlash(p))
+	}
+
+	return files
+}
+
+// snapshotMessage renders the message of a snapshot made by an event, the label trailer is kept whatever the template
+func (s *Session) snapshotMessage(event string, message string, paths []string, label string) (string, error) {
+	snapshot, _ := messageTemplates()
+
+	msg, err := render("snapshot", snapshot, SnapshotData{
+		Session: s.current.Name,
+		Event:   event,
+		Message: message,
+		Label:   label,
+		Files:   relFiles(paths),
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if label != "" {
+		msg = repository.AddTrailer(msg, LabelTrailer, label)
+	}
+
+	return msg, nil
+}
+
+// squashMessage renders the message of the commit squashing the session into its source branch
+func (s *Session) squashMessage(message string) (string, error) {
+	_, squash := messageTemplates()
+
+	commits, files, err := s.touchedFiles()
+	if err != nil {
+		return "", err
+	}
+
+	return render("squash", squash, SquashData{
+		Session:   s.Info.Name,
+		Source:    s.Info.Source,
+		Message:   message,
+		Snapshots: len(commits),
+		Files:     files,
+		Time:      time.Now(),
+	})
+}
+
+// touchedFiles returns the snapshots of the session and the files they changed
+func (s *Session) touchedFiles() ([]repository.CommitInfo, []string, error) {
+	commits, err := sessionCommits(s.r, s.Info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.r.LoadStats(commits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, c := range commits {
+		for _, f := range c.Files {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	return commits, files, nil
+}
@@ -0,0 +1,254 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/config"
+	"chrono/pkg/control"
+	"chrono/pkg/event/event"
+	"chrono/pkg/scheduler"
+	"chrono/pkg/signal"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// Editors often write a file in several steps, wait until they are done
+const reloadDebounce = 500 * time.Millisecond
+
+// How long `session reload` waits for the running session to apply its config
+const reloadTimeout = 60 * time.Second
+
+// runningEvent is an event instance along with the entry of the config it was created from
+type runningEvent struct {
+	entry event.Entry
+	event event.Event
+}
+
+func (s *Session) startEvents(entries []event.Entry) error {
+	for _, entry := range entries {
+		e, err := entry.New()
+		if err != nil {
+			return err
+		}
+
+		err = scheduler.AddEvent(e)
+		if err != nil {
+			return err
+		}
+
+		s.events = append(s.events, runningEvent{entry: entry, event: e})
+	}
+
+	return nil
+}
+
+// watchConfig reloads the config whenever one of its files changes, or when SIGHUP is received
+func (s *Session) watchConfig(ctx context.Context) {
+	var changes chan fsnotify.Event
+	var errs chan error
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warn().Err(err).Msg("Couldn't watch the config files, send SIGHUP to reload it")
+	} else {
+		defer w.Close()
+		changes, errs = w.Events, w.Errors
+
+		// Directories are watched since editors may replace files instead of writing them
+		for _, path := range config.Files(chrono.RootPath) {
+			dir := filepath.Dir(path)
+			if _, err := os.Stat(dir); err != nil {
+				continue
+			}
+
+			err = w.Add(dir)
+			if err != nil {
+				log.Warn().Err(err).Str("dir", dir).Msg("Couldn't watch config directory")
+			}
+		}
+	}
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev := <-changes:
+			if config.IsFile(chrono.RootPath, ev.Name) {
+				timer = time.After(reloadDebounce)
+			}
+
+		case err := <-errs:
+			log.Warn().Err(err).Msg("Config watcher error")
+
+		case <-signal.Hup:
+			log.Info().Msg("Reload requested")
+			s.reload()
+
+		case <-timer:
+			timer = nil
+			s.reload()
+		}
+	}
+}
+
+func (s *Session) reload() {
+	err := s.Reload()
+	if err != nil {
+		log.Error().Err(err).Msg("Config not reloaded, the current one is kept")
+	}
+}
+
+// Reload applies the current config files to the running session, only the events whose
+// config changed are restarted, an invalid config is rejected as a whole
+func (s *Session) Reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	loaded, err := config.Read(chrono.RootPath)
+	if err != nil {
+		return err
+	}
+
+	if mode(loaded.Cfg) != mode(config.Cfg) {
+		return errors.New("git.mode can't change while the session is running, restart it instead")
+	}
+
+	entries, err := event.Entries(loaded.Cfg.Events)
+	if err != nil {
+		return err
+	}
+
+	err = validateTemplates(loaded.Cfg.Messages)
+	if err != nil {
+		return err
+	}
+
+	// New events are all started before anything is stopped, so that a failure leaves the session as it was
+	previous := make(map[string]runningEvent, len(s.events))
+	for _, r := range s.events {
+		previous[r.entry.Name] = r
+	}
+
+	next := make([]runningEvent, 0, len(entries))
+	created := []runningEvent{}
+	for _, entry := range entries {
+		r, ok := previous[entry.Name]
+		if ok && r.entry.Type == entry.Type && reflect.DeepEqual(r.entry.Config, entry.Config) {
+			delete(previous, entry.Name)
+			next = append(next, runningEvent{entry: entry, event: r.event})
+			continue
+		}
+
+		e, err := entry.New()
+		if err != nil {
+			return err
+		}
+
+		r = runningEvent{entry: entry, event: e}
+		next = append(next, r)
+		created = append(created, r)
+	}
+
+	if !reflect.DeepEqual(loaded.Cfg.Backup, config.Cfg.Backup) ||
+		autoCompactOf(loaded.Cfg) != autoCompactOf(config.Cfg) {
+		log.Warn().Msg("Backup and auto-compact changes apply once the session is restarted")
+	}
+
+	started := []runningEvent{}
+	undo := func() {
+		for _, r := range started {
+			scheduler.RemoveEvent(r.event)
+		}
+	}
+
+	for _, r := range created {
+		err = scheduler.AddEvent(r.event)
+		if err != nil {
+			undo()
+			return fmt.Errorf("event %v: %w", r.entry.Name, err)
+		}
+		started = append(started, r)
+	}
+
+	err = scheduler.Do(func() {
+		config.Use(loaded)
+	})
+	if err != nil {
+		undo()
+		return err
+	}
+
+	for _, r := range started {
+		log.Info().Str("event", r.entry.Name).Msg("Started event")
+	}
+
+	for name, r := range previous {
+		scheduler.RemoveEvent(r.event)
+		log.Info().Str("event", name).Msg("Stopped event")
+	}
+
+	s.events = next
+
+	err = s.recordConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't save the config of the session")
+	}
+
+	log.Info().Int("started", len(started)).Int("stopped", len(previous)).Msg("Config reloaded")
+	return nil
+}
+
+func (s *Session) handleReload(req control.Request) control.Response {
+	if !s.started.Load() {
+		return control.Response{Error: "the session is still starting"}
+	}
+
+	err := s.Reload()
+	if err != nil {
+		return control.Response{Error: err.Error()}
+	}
+
+	return control.Response{OK: true, Message: "Config reloaded"}
+}
+
+// ReloadConfig asks the process running a session to reload its config
+func ReloadConfig(name string) error {
+	_, err := GetSession(name)
+	if err != nil {
+		return err
+	}
+
+	l, err := lock.Read(chrono.RootPath, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = control.Send(l.Socket, control.Request{Command: "reload"}, reloadTimeout)
+	return err
+}
+
+func mode(cfg config.CfgRoot) string {
+	if cfg.Git == nil || cfg.Git.Mode == "" {
+		return config.ModeBranch
+	}
+
+	return cfg.Git.Mode
+}
+
+func autoCompactOf(cfg config.CfgRoot) time.Duration {
+	if cfg.Retention == nil {
+		return 0
+	}
+
+	return cfg.Retention.AutoCompact
+}
@@ -0,0 +1,162 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"chrono/pkg/repository"
+)
+
+var hashRegexp = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+var agoRegexp = regexp.MustCompile(`^(\d+)\s*([a-zA-Z]+)\s+ago$`)
+var relativeRegexp = regexp.MustCompile(`^~(\d*)$`)
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"15:04:05 02/01/2006",
+	"02/01/2006 15:04:05",
+}
+
+var clockLayouts = []string{
+	"15:04:05",
+	"15:04",
+}
+
+var units = map[string]time.Duration{
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+	"w":      7 * 24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// ResolveSnapshot finds the session commit designated by ref, either a label, a hash prefix,
+// a number of snapshots before the last one ("~3") or a point in time
+func ResolveSnapshot(commits []repository.CommitInfo, labels map[string]string, ref string) (repository.CommitInfo, error) {
+	ref = strings.TrimSpace(ref)
+
+	if hash, ok := labels[ref]; ok {
+		for _, c := range commits {
+			if c.Hash == hash {
+				return c, nil
+			}
+		}
+
+		return repository.CommitInfo{}, fmt.Errorf("label %q points to %v which isn't a snapshot of the session", ref, hash[:8])
+	}
+
+	if m := relativeRegexp.FindStringSubmatch(ref); m != nil {
+		n := 1
+		if m[1] != "" {
+			n, _ = strconv.Atoi(m[1])
+		}
+
+		if n >= len(commits) {
+			return repository.CommitInfo{}, fmt.Errorf("%v: the session only has %v snapshots", ref, len(commits))
+		}
+
+		return commits[n], nil
+	}
+
+	if hashRegexp.MatchString(ref) {
+		var found []repository.CommitInfo
+		for _, c := range commits {
+			if strings.HasPrefix(c.Hash, strings.ToLower(ref)) {
+				found = append(found, c)
+			}
+		}
+
+		if len(found) == 1 {
+			return found[0], nil
+		}
+
+		if len(found) > 1 {
+			return repository.CommitInfo{}, fmt.Errorf("ambiguous commit %q, matches %v snapshots", ref, len(found))
+		}
+	}
+
+	t, err := parseTime(ref, time.Now())
+	if err != nil {
+		return repository.CommitInfo{}, fmt.Errorf("%q is neither a label, a snapshot hash, a relative snapshot nor a time", ref)
+	}
+
+	var best *repository.CommitInfo
+	for i, c := range commits {
+		if c.When.After(t) {
+			continue
+		}
+
+		if best == nil || c.When.After(best.When) {
+			best = &commits[i]
+		}
+	}
+
+	if best == nil {
+		return repository.CommitInfo{}, fmt.Errorf("no snapshot at or before %v", t.Format("15:04:05 02/01/2006"))
+	}
+
+	return *best, nil
+}
+
+// parseTime understands absolute timestamps, times of the current day and relative times like "10 minutes ago"
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+
+	if m := agoRegexp.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		unit, ok := units[strings.TrimSuffix(strings.ToLower(m[2]), "s")]
+		if !ok {
+			unit, ok = units[strings.ToLower(m[2])]
+		}
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown time unit %q", m[2])
+		}
+
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+
+	if d, err := time.ParseDuration(strings.TrimSpace(strings.TrimSuffix(s, "ago"))); err == nil && strings.HasSuffix(s, "ago") {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range clockLayouts {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("couldn't parse time %q", s)
+}
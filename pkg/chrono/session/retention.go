@@ -0,0 +1,199 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/config"
+	"chrono/pkg/control"
+	"chrono/pkg/repository"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNoRetention = errors.New("no retention policy configured")
+
+func retentionRules() []config.CfgRetentionRule {
+	if config.Cfg.Retention == nil {
+		return nil
+	}
+
+	return config.Cfg.Retention.Rules
+}
+
+// retained selects the snapshots kept by the retention rules among commits sorted newest first,
+// in each period of a rule the most recent snapshot is kept, labeled snapshots are always kept
+func retained(commits []repository.CommitInfo, labeled map[string]bool, rules []config.CfgRetentionRule, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	buckets := make(map[string]bool)
+
+	for i, c := range commits {
+		if i == 0 || labeled[c.Hash] {
+			keep[c.Hash] = true
+			continue
+		}
+
+		age := now.Sub(c.When)
+		for n, rule := range rules {
+			if rule.Within > 0 && age > rule.Within {
+				continue
+			}
+
+			if rule.Every <= 0 {
+				keep[c.Hash] = true
+				break
+			}
+
+			bucket := fmt.Sprintf("%v/%v", n, c.When.Truncate(rule.Every).Unix())
+			if !buckets[bucket] {
+				buckets[bucket] = true
+				keep[c.Hash] = true
+			}
+			break
+		}
+	}
+
+	return keep
+}
+
+// compact thins the snapshots of a session out according to the retention policy, and tells how many are left
+func (s *Session) compact(def SessionDef) (string, error) {
+	rules := retentionRules()
+	if len(rules) == 0 {
+		return "", ErrNoRetention
+	}
+
+	commits, err := sessionCommits(s.r, def)
+	if err != nil {
+		return "", err
+	}
+
+	l, err := labels(s.r, def.Name, commits)
+	if err != nil {
+		return "", err
+	}
+
+	labeled := make(map[string]bool)
+	for _, hash := range l {
+		labeled[hash] = true
+	}
+
+	keep := retained(commits, labeled, rules, time.Now())
+	if len(keep) == len(commits) {
+		return fmt.Sprintf("Nothing to compact, %v snapshots", len(commits)), nil
+	}
+
+	ids, err := s.r.RewriteChain(def.RefName(), def.BaseOID, func(hash string) bool {
+		return keep[hash]
+	})
+	if err != nil {
+		return "", err
+	}
+
+	marks, err := s.r.ListRefs(marksPrefix(def.Name))
+	if err != nil {
+		return "", err
+	}
+
+	for name, hash := range marks {
+		if id, ok := ids[hash]; ok && id != hash {
+			err = s.r.SetRef(name, id, "chrono: compacted")
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	err = chrono.UpdateSession(def.Name, func(d *SessionDef) {
+		if id, ok := ids[d.LastSnapshot]; ok {
+			d.LastSnapshot = id
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Compacted %v snapshots into %v", len(commits), len(keep)), nil
+}
+
+// handleCompact compacts the session, or one of its sub-sessions, from the process running it
+func (s *Session) handleCompact(req control.Request) control.Response {
+	def, err := GetSession(req.Args["session"])
+	if err != nil {
+		return control.Response{Error: err.Error()}
+	}
+
+	msg, err := s.compact(def)
+	if err != nil {
+		return control.Response{Error: err.Error()}
+	}
+
+	return control.Response{OK: true, Message: msg}
+}
+
+// autoCompact compacts the session being committed to periodically until ctx is done
+func (s *Session) autoCompact(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			def, err := GetSession(s.current.Name)
+			if err == nil {
+				var msg string
+				msg, err = s.compact(def)
+				if err == nil {
+					log.Info().Str("session", def.Name).Msg(msg)
+				}
+			}
+
+			if err != nil {
+				log.Error().Err(err).Msg("Auto compaction failed")
+			}
+		}
+	}
+}
+
+// Compact thins the snapshots of a session out according to the retention policy,
+// the process running the session does it if there is one
+func Compact(name string) (string, error) {
+	def, err := GetSession(name)
+	if err != nil {
+		return "", err
+	}
+
+	root := def.Name
+	if def.Parent != "" {
+		root = def.Parent
+	}
+
+	l, err := lock.Read(chrono.RootPath, root)
+	if err == nil {
+		res, err := control.Send(l.Socket, control.Request{
+			Command: "compact",
+			Args:    map[string]string{"session": name},
+		}, stopTimeout)
+		if err != nil {
+			return "", err
+		}
+
+		return res.Message, nil
+	}
+	if !errors.Is(err, lock.ErrNotRunning) {
+		return "", err
+	}
+
+	s, err := OpenSession(name)
+	if err != nil {
+		return "", err
+	}
+
+	return s.compact(def)
+}
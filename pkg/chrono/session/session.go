@@ -1,189 +1,535 @@
 package session
 
 import (
+	"chrono/pkg/backup"
 	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
 	"chrono/pkg/config"
-	"chrono/pkg/event/periodic"
-	"chrono/pkg/event/save"
+	"chrono/pkg/control"
+	_ "chrono/pkg/event/command"
+	"chrono/pkg/event/event"
+	"chrono/pkg/event/hook"
+	_ "chrono/pkg/event/idle"
+	_ "chrono/pkg/event/periodic"
+	_ "chrono/pkg/event/save"
 	"chrono/pkg/repository"
 	"chrono/pkg/scheduler"
 	"chrono/pkg/signal"
 	"context"
 	"encoding/json"
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-type SessionDef struct {
-	Name   string `json: "Name"`
-	Branch string `json: "Branch"`
-	Source string `json: "Source"`
-}
+// How long `session stop` waits for the running session to shut down
+const stopTimeout = 60 * time.Second
+
+var ErrSessionNotFound = errors.New("session of that name doesn't exist")
+var ErrSessionExists = errors.New("session of that name already exists")
+
+type SessionDef = chrono.SessionDef
 
 type Session struct {
-	Info SessionDef
-	r    *repository.Repository
-}
+	Info   SessionDef
+	r      *repository.Repository
+	events []runningEvent
+
+	// Held while the config is being reloaded, which can only happen once the events are started
+	reloading sync.Mutex
+	started   atomic.Bool
 
-func GetSessionCommits(sessionName string) []repository.CommitInfo {
-	sessionsPath := filepath.Join(chrono.RootPath, chrono.DotChronoDirName, chrono.SessionsFileName)
+	// The sub-session being committed to when following branch changes
+	current SessionDef
+	paused  bool
+}
 
-	file, err := os.ReadFile(sessionsPath)
+func GetSessionCommits(sessionName string) ([]repository.CommitInfo, error) {
+	info, err := GetSession(sessionName)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		return nil, err
 	}
 
-	sessions := make(map[string]SessionDef)
-	err = json.Unmarshal(file, &sessions)
+	r, err := repository.Open(chrono.RootPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		return nil, err
 	}
 
-	r := repository.Open(chrono.RootPath)
-	commits := r.GetCommits(sessions[sessionName].Branch)
+	return sessionCommits(r, info)
+}
+
+// sessionCommits lists the snapshots of a session, from its base commit to its tip, leaving out
+// the commits of its source branch as long as the session isn't merged into it
+func sessionCommits(r *repository.Repository, def SessionDef) ([]repository.CommitInfo, error) {
+	var hide []string
+	if def.Status == chrono.StatusActive && def.Source != "" {
+		hide = append(hide, "refs/heads/"+def.Source)
+	}
 
-	return commits
+	return r.GetCommits(def.RefName(), def.BaseOID, hide)
 }
 
-func GetSessions() map[string]SessionDef {
-	sessionsPath := filepath.Join(chrono.RootPath, chrono.DotChronoDirName, chrono.SessionsFileName)
+func GetSessions() (map[string]SessionDef, error) {
+	return chrono.LoadSessions()
+}
 
-	file, err := os.ReadFile(sessionsPath)
+func GetSession(name string) (SessionDef, error) {
+	sessions, err := GetSessions()
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		return SessionDef{}, err
 	}
 
-	sessions := make(map[string]SessionDef)
+	info, ok := sessions[name]
+	if !ok {
+		return SessionDef{}, fmt.Errorf("%w: %v", ErrSessionNotFound, name)
+	}
+
+	return info, nil
+}
+
+func OpenSession(name string) (*Session, error) {
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("repository", chrono.RootPath).Msg("Opened GIT repository")
 
-	err = json.Unmarshal(file, &sessions)
+	info, err := GetSession(name)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		return nil, err
 	}
 
-	return sessions
+	return &Session{
+		Info:    info,
+		r:       r,
+		current: info,
+	}, nil
 }
 
-func OpenSession(name string) *Session {
-	r := repository.Open(chrono.RootPath)
+func CreateSession(name string) error {
+	r, err := repository.Open(chrono.RootPath)
+	if err != nil {
+		return err
+	}
 	log.Info().Str("repository", chrono.RootPath).Msg("Opened GIT repository")
 
-	info, ok := GetSessions()[name]
-	if !ok {
-		log.Fatal().Str("name", name).Msg("Session of that name doesn't exist")
+	source, err := r.GetBranchName()
+	if err != nil {
+		return err
 	}
 
-	return &Session{
-		Info: info,
-		r:    r,
+	shadow := config.Cfg.Git != nil && config.Cfg.Git.Mode == config.ModeShadow
+
+	var def SessionDef
+	created := false
+	err = chrono.UpdateSessions(func(sessions map[string]SessionDef) error {
+		if _, ok := sessions[name]; ok {
+			return fmt.Errorf("%w: %v", ErrSessionExists, name)
+		}
+
+		def, err = createSessionRef(r, name, source, shadow)
+		if err != nil {
+			return err
+		}
+
+		created = true
+		sessions[name] = def
+		return nil
+	})
+
+	if err != nil && created {
+		if delErr := deleteSessionRef(r, def); delErr != nil {
+			log.Error().Err(delErr).Str("ref", def.RefName()).Msg("Couldn't delete session reference")
+		}
 	}
+
+	return err
 }
 
-func CreateSession(name string) {
-	r := repository.Open(chrono.RootPath)
-	log.Info().Str("repository", chrono.RootPath).Msg("Opened GIT repository")
+// createSessionRef creates the branch (or the shadow reference) of a session from the current HEAD
+func createSessionRef(r *repository.Repository, name string, source string, shadow bool) (SessionDef, error) {
+	base, err := r.HeadId()
+	if err != nil {
+		return SessionDef{}, err
+	}
 
-	sessions := GetSessions()
+	def := SessionDef{
+		Name:      name,
+		Source:    source,
+		CreatedAt: time.Now(),
+		BaseOID:   base,
+		Status:    chrono.StatusActive,
+	}
 
-	if _, ok := sessions[name]; ok {
-		log.Fatal().Str("name", name).Msg("Session of that name already exists")
+	if shadow {
+		def.Ref = chrono.ShadowRefPrefix + name
+		return def, r.CreateRef(def.Ref)
 	}
 
 	var sb strings.Builder
 	sb.WriteString("chrono/")
 	sb.WriteString(name)
-	branchName := sb.String()
 
-	r.CreateBranch(branchName)
+	def.Branch = sb.String()
+	return def, r.CreateBranch(def.Branch)
+}
 
-	sessions[name] = SessionDef{
-		Name:   name,
-		Branch: branchName,
-		Source: r.GetBranchName(),
+func deleteSessionRef(r *repository.Repository, def SessionDef) error {
+	if def.Shadow() {
+		return r.DeleteRef(def.RefName())
 	}
 
-	sessionsPath := filepath.Join(chrono.RootPath, chrono.DotChronoDirName, chrono.SessionsFileName)
-	bytes, err := json.Marshal(&sessions)
-	if err != nil {
-		r.DeleteBranch(branchName)
-		log.Fatal().Err(err).Msg("Marshal error")
-	}
+	return r.DeleteBranch(def.Branch)
+}
 
-	err = os.WriteFile(sessionsPath, bytes, os.ModePerm)
+func DeleteSession(name string) error {
+	r, err := repository.Open(chrono.RootPath)
 	if err != nil {
-		r.DeleteBranch(branchName)
-		log.Fatal().Err(err).Msg("Error")
+		return err
 	}
-}
-
-func DeleteSession(name string) {
-	r := repository.Open(chrono.RootPath)
 	log.Info().Str("repository", chrono.RootPath).Msg("Opened GIT repository")
 
-	sessions := GetSessions()
+	return chrono.UpdateSessions(func(sessions map[string]SessionDef) error {
+		s, ok := sessions[name]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrSessionNotFound, name)
+		}
 
-	s, ok := sessions[name]
+		if _, err := lock.Read(chrono.RootPath, name); err == nil {
+			return fmt.Errorf("%w, stop it before deleting it", lock.ErrAlreadyRunning)
+		}
 
-	if !ok {
-		log.Fatal().Str("name", name).Msg("Session of that name doesn't exist")
-	}
+		err = deleteSessionRef(r, s)
+		if err != nil {
+			return err
+		}
+
+		err = deleteMarks(r, name)
+		if err != nil {
+			return err
+		}
+
+		delete(sessions, name)
+		return nil
+	})
+}
 
-	r.DeleteBranch(s.Branch)
-	delete(sessions, name)
+// Abandon marks a session as abandoned, its history is kept but it isn't meant to be merged anymore
+func Abandon(name string) error {
+	return setStatus(name, chrono.StatusAbandoned)
+}
+
+func setStatus(name string, status string) error {
+	return chrono.UpdateSessions(func(sessions map[string]SessionDef) error {
+		s, ok := sessions[name]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrSessionNotFound, name)
+		}
+
+		s.Status = status
+		sessions[name] = s
+		return nil
+	})
+}
 
-	sessionsPath := filepath.Join(chrono.RootPath, chrono.DotChronoDirName, chrono.SessionsFileName)
-	bytes, err := json.Marshal(&sessions)
+func (s *Session) Start() error {
+	l, err := lock.Acquire(chrono.RootPath, s.Info.Name)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Marshal error")
+		return err
 	}
+	defer l.Release()
 
-	err = os.WriteFile(sessionsPath, bytes, os.ModePerm)
+	server, err := control.Listen(l.Socket)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		return fmt.Errorf("couldn't open control socket %v: %w", l.Socket, err)
 	}
-}
+	defer server.Close()
 
-func (s *Session) Start() {
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	server.Handle("stop", func(req control.Request) control.Response {
+		log.Info().Msg("Stop requested")
+		cancel()
+		<-stopped
+		return control.Response{OK: true, Message: "Session stopped"}
+	})
+	server.Handle("snap", s.handleSnap)
+	server.Handle("compact", s.handleCompact)
+	server.Handle("reload", s.handleReload)
+	server.Handle("hook", func(req control.Request) control.Response {
+		err := hook.Trigger(req.Args["hook"])
+		if err != nil {
+			return control.Response{Error: err.Error()}
+		}
+		return control.Response{OK: true}
+	})
+	go server.Serve()
 
 	go func() {
-		for {
-			select {
-			case <-signal.Ch:
-				cancel()
-				wg.Wait()
-				os.Exit(0)
-			}
+		select {
+		case <-signal.Ch:
+			log.Info().Msg("Interrupted")
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
 
-	s.r.CheckoutBranch(s.Info.Branch)
+	entries, err := event.Entries(config.Cfg.Events)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateTemplates()
+	if err != nil {
+		return err
+	}
+
+	err = s.prepare()
+	if err != nil {
+		return err
+	}
+
+	err = s.recordConfig()
+	if err != nil {
+		return err
+	}
+
+	var b *backup.Backup
+	if cfg, ok := backup.Configured(); ok {
+		b = backup.New(s.r, cfg)
+	}
 
 	scheduler.Init(ctx)
 	scheduler.SetRepository(s.r)
+	scheduler.SetGuard(s.guard)
+	scheduler.SetCommitHook(func(msg scheduler.SchedulerMessage, id string) {
+		s.recordSnapshot(id)
+		if b != nil {
+			b.Snapshotted()
+		}
+	})
+	scheduler.SetFormatter(func(msg scheduler.SchedulerMessage) (string, error) {
+		return s.snapshotMessage(msg.Sender, msg.Message, msg.Paths, msg.Label)
+	})
 
+	var runErr error
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		scheduler.Run()
+		runErr = scheduler.Run()
+		cancel()
 		scheduler.Fini()
 	}()
 
-	if config.Cfg.Events.Periodic != nil {
-		scheduler.AddEvent(periodic.Periodic)
+	if config.Cfg.Retention != nil && config.Cfg.Retention.AutoCompact > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.autoCompact(ctx, config.Cfg.Retention.AutoCompact)
+		}()
+	}
+
+	if b != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Run(ctx)
+		}()
 	}
 
-	if config.Cfg.Events.Save != nil {
-		scheduler.AddEvent(save.Save)
+	s.reloading.Lock()
+	err = s.startEvents(entries)
+	s.started.Store(err == nil)
+	s.reloading.Unlock()
+
+	if err != nil {
+		cancel()
+		wg.Wait()
+		return err
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.watchConfig(ctx)
+	}()
+
 	wg.Wait()
+
+	if runErr != nil {
+		return runErr
+	}
+
+	return s.finalCommit()
+}
+
+// prepare makes the repository commit to the session, checking out its branch unless it is a shadow session
+func (s *Session) prepare() error {
+	if s.Info.Shadow() {
+		return s.r.UseShadowRef(s.Info.RefName())
+	}
+
+	return s.r.CheckoutBranch(s.Info.Branch)
+}
+
+// prepareStopped makes the repository commit to a session which isn't running without touching HEAD:
+// unless its branch is the one checked out, it is written to directly like a shadow session
+func (s *Session) prepareStopped() error {
+	if !s.Info.Shadow() {
+		current, err := s.r.GetBranchName()
+		if err == nil && current == s.Info.Branch {
+			return nil
+		}
+	}
+
+	return s.r.UseShadowRef(s.Info.RefName())
+}
+
+// recordConfig saves the configuration the session runs with in its metadata
+func (s *Session) recordConfig() error {
+	cfg, err := json.Marshal(&config.Cfg)
+	if err != nil {
+		return err
+	}
+
+	return chrono.UpdateSession(s.Info.Name, func(d *SessionDef) {
+		d.Config = cfg
+	})
+}
+
+func (s *Session) recordSnapshot(id string) {
+	err := chrono.UpdateSession(s.current.Name, func(d *SessionDef) {
+		d.LastSnapshot = id
+		d.LastSnapshotAt = time.Now()
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Couldn't save last snapshot")
+	}
+}
+
+// finalCommit records whatever changed since the last event before the session stops
+func (s *Session) finalCommit() error {
+	paths := []string{}
+	for _, r := range s.events {
+		if fe, ok := r.event.(event.FileEvent); ok {
+			paths = append(paths, fe.Paths()...)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	ok, err := s.guard()
+	if err != nil || !ok {
+		return err
+	}
+
+	message, err := s.snapshotMessage("Stop", fmt.Sprintf("[Stop] %v", time.Now().Format("15:04:05 02/01/2006")), paths, "")
+	if err != nil {
+		return err
+	}
+
+	id, err := s.r.Commit(paths, "Stop", message)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		s.recordSnapshot(id)
+	}
+
+	return nil
+}
+
+// Stop asks the process running a session to stop gracefully and waits for it
+func Stop(name string) error {
+	_, err := GetSession(name)
+	if err != nil {
+		return err
+	}
+
+	stale := lock.IsStale(chrono.RootPath, name)
+
+	l, err := lock.Read(chrono.RootPath, name)
+	if errors.Is(err, lock.ErrNotRunning) && stale {
+		log.Warn().Str("session", name).Msg("Removed stale lock left by a crashed process")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = control.Send(l.Socket, control.Request{Command: "stop"}, stopTimeout)
+	if err != nil {
+		return fmt.Errorf("couldn't stop session (pid %v): %w", l.PID, err)
+	}
+
+	return nil
 }
 
-func (s *Session) SquashMerge(msg string) {
-	s.r.SquashMerge(s.Info.Source, s.Info.Branch, msg)
+// Restore brings the working tree back to a snapshot, the current state is committed first so that it can be undone
+func (s *Session) Restore(ref string, paths []string) error {
+	// The running session would snapshot the working tree while it is being restored
+	if _, err := lock.Read(chrono.RootPath, s.Info.Name); err == nil {
+		return fmt.Errorf("%w, stop it before restoring it", lock.ErrAlreadyRunning)
+	}
+
+	err := s.prepareStopped()
+	if err != nil {
+		return err
+	}
+
+	commits, err := sessionCommits(s.r, s.Info)
+	if err != nil {
+		return err
+	}
+
+	labels, err := s.labels(commits)
+	if err != nil {
+		return err
+	}
+
+	target, err := ResolveSnapshot(commits, labels, ref)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("hash", target.Hash[:8]).Time("when", target.When).Msg("Restoring snapshot")
+
+	now := time.Now().Format("15:04:05 02/01/2006")
+	message, err := s.snapshotMessage("Restore", fmt.Sprintf("[Restore] Before restoring to %v %v", target.Hash[:8], now), nil, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.r.Commit(nil, "Restore", message)
+	if err != nil {
+		return err
+	}
+
+	message, err = s.snapshotMessage("Restore", fmt.Sprintf("[Restore] Restored %v %v", target.Hash[:8], now), paths, "")
+	if err != nil {
+		return err
+	}
+
+	id, err := s.r.Restore(target.Hash, paths, "Restore", message)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		s.recordSnapshot(id)
+	}
+
+	return nil
 }
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/chrono/lock"
+	"chrono/pkg/control"
+	"chrono/pkg/repository"
+	"chrono/pkg/scheduler"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LabelTrailer is the git trailer holding the label of a snapshot
+const LabelTrailer = "Chrono-Label"
+
+var labelRegexp = regexp.MustCompile(`^[\w.-]+(/[\w.-]+)*$`)
+
+var ErrInvalidLabel = errors.New("invalid label, only letters, digits, '.', '-', '_' and '/' are allowed")
+
+func ValidateLabel(label string) error {
+	if !labelRegexp.MatchString(label) || strings.Contains(label, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidLabel, label)
+	}
+
+	return nil
+}
+
+// Summary is the first line of a commit message
+func Summary(message string) string {
+	summary, _, _ := strings.Cut(message, "\n")
+	return summary
+}
+
+// Label returns the label of a snapshot found in its commit message, if any
+func Label(message string) string {
+	return repository.Trailer(message, LabelTrailer)
+}
+
+func snapMessage(msg string) string {
+	var sb strings.Builder
+	sb.WriteString("[Snap] ")
+
+	if msg != "" {
+		sb.WriteString(msg)
+		sb.WriteString(" ")
+	}
+	sb.WriteString(time.Now().Format("15:04:05 02/01/2006"))
+
+	return sb.String()
+}
+
+// handleSnap commits the whole working tree into the running session
+func (s *Session) handleSnap(req control.Request) control.Response {
+	err := scheduler.NotifyWait(scheduler.SchedulerMessage{
+		Sender:  "Snap",
+		Message: snapMessage(req.Args["message"]),
+		Label:   req.Args["label"],
+	})
+	if err != nil {
+		return control.Response{Error: err.Error()}
+	}
+
+	return control.Response{OK: true, Message: "Snapshot taken"}
+}
+
+// Snap takes a snapshot of the whole working tree into a session, the running session process
+// commits it if there is one, otherwise it is committed directly without checking the session out
+func Snap(name string, msg string, label string) error {
+	if label != "" {
+		err := ValidateLabel(label)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := GetSession(name); err != nil {
+		return err
+	}
+
+	l, err := lock.Read(chrono.RootPath, name)
+	if err == nil {
+		log.Info().Int("pid", l.PID).Msg("Session is running, sending snapshot request")
+
+		_, err = control.Send(l.Socket, control.Request{
+			Command: "snap",
+			Args:    map[string]string{"message": msg, "label": label},
+		}, stopTimeout)
+		return err
+	}
+	if !errors.Is(err, lock.ErrNotRunning) {
+		return err
+	}
+
+	s, err := OpenSession(name)
+	if err != nil {
+		return err
+	}
+
+	err = s.prepareStopped()
+	if err != nil {
+		return err
+	}
+
+	message, err := s.snapshotMessage("Snap", snapMessage(msg), nil, label)
+	if err != nil {
+		return err
+	}
+
+	id, err := s.r.Commit(nil, "Snap", message)
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		log.Info().Msg("Nothing changed since the last snapshot")
+		return nil
+	}
+
+	s.recordSnapshot(id)
+	return nil
+}
+
+// DefaultSession picks the session a command applies to when none is given:
+// the running one, or else the only active one
+func DefaultSession() (string, error) {
+	sessions, err := GetSessions()
+	if err != nil {
+		return "", err
+	}
+
+	var running, active []string
+	for name, s := range sessions {
+		if _, err := lock.Read(chrono.RootPath, name); err == nil {
+			running = append(running, name)
+		}
+		if s.Status == chrono.StatusActive {
+			active = append(active, name)
+		}
+	}
+
+	if len(running) == 1 {
+		return running[0], nil
+	}
+
+	if len(running) == 0 && len(active) == 1 {
+		return active[0], nil
+	}
+
+	return "", errors.New("Please specify a session name")
+}
@@ -0,0 +1,271 @@
+package chrono
+
+import (
+	"chrono/pkg/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SchemaVersion is the version of the sessions file written by this version of chrono
+const SchemaVersion int = 1
+
+const SessionsLockFileName string = "sessions.lock"
+
+// Shadow sessions commit to refs/chrono/<name> instead of a checked out branch
+const ShadowRefPrefix string = "refs/chrono/"
+
+const StatusActive string = "active"
+const StatusMerged string = "merged"
+const StatusAbandoned string = "abandoned"
+
+type SessionDef struct {
+	Name   string `json:"Name"`
+	Branch string `json:"Branch,omitempty"`
+	Source string `json:"Source"`
+	Ref    string `json:"Ref,omitempty"`
+	Parent string `json:"Parent,omitempty"`
+
+	CreatedAt      time.Time       `json:"CreatedAt"`
+	BaseOID        string          `json:"BaseOID,omitempty"`
+	LastSnapshot   string          `json:"LastSnapshot,omitempty"`
+	LastSnapshotAt time.Time       `json:"LastSnapshotAt"`
+	Status         string          `json:"Status"`
+	Config         json.RawMessage `json:"Config,omitempty"`
+}
+
+// RefName is the full name of the reference the session commits to
+func (d SessionDef) RefName() string {
+	if d.Ref != "" {
+		return d.Ref
+	}
+
+	return "refs/heads/" + d.Branch
+}
+
+// Shadow tells whether the session commits in the background without checking out its branch
+func (d SessionDef) Shadow() bool {
+	return strings.HasPrefix(d.RefName(), ShadowRefPrefix)
+}
+
+type sessionsFile struct {
+	Version  int                   `json:"Version"`
+	Sessions map[string]SessionDef `json:"Sessions"`
+}
+
+// migrations[v] upgrades the raw content of a version v sessions file to version v+1
+var migrations = []func(raw []byte) ([]byte, error){
+	migrateV0,
+}
+
+// Version 0 was a flat map of sessions, without any metadata
+func migrateV0(raw []byte) ([]byte, error) {
+	sessions := make(map[string]SessionDef)
+	err := json.Unmarshal(raw, &sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a base, the whole history would be walked to list the snapshots of a session,
+	// it is where the session forked from its source branch
+	r, err := repository.Open(RootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, s := range sessions {
+		s.Status = StatusActive
+
+		if s.BaseOID == "" && s.Source != "" {
+			// A source branch deleted since is left as it was
+			if base, err := r.MergeBase(s.RefName(), "refs/heads/"+s.Source); err == nil {
+				s.BaseOID = base
+			}
+		}
+
+		sessions[name] = s
+	}
+
+	return json.Marshal(&sessionsFile{Version: 1, Sessions: sessions})
+}
+
+func sessionsPath() string {
+	return filepath.Join(RootPath, DotChronoDirName, SessionsFileName)
+}
+
+// lockSessions takes an advisory lock on the sessions file, shared for reading and exclusive for writing,
+// a separate lock file is used since the sessions file itself gets replaced on each write
+func lockSessions(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(RootPath, DotChronoDirName, SessionsLockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open sessions lock: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err = syscall.Flock(int(f.Fd()), how)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't lock sessions file: %w", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// readSessions reads and migrates the sessions file, the caller must hold the lock
+func readSessions() (map[string]SessionDef, error) {
+	raw, err := os.ReadFile(sessionsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]SessionDef), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read sessions file: %w", err)
+	}
+
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse sessions file: %w", err)
+	}
+
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("sessions file version %v is newer than supported version %v, please update chrono", version, SchemaVersion)
+	}
+
+	for ; version < SchemaVersion; version++ {
+		raw, err = migrations[version](raw)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't migrate sessions file from version %v: %w", version, err)
+		}
+	}
+
+	var file sessionsFile
+	err = json.Unmarshal(raw, &file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse sessions file: %w", err)
+	}
+
+	if file.Sessions == nil {
+		file.Sessions = make(map[string]SessionDef)
+	}
+
+	return file.Sessions, nil
+}
+
+// schemaVersion tells the version of a sessions file, version 0 files have no header
+func schemaVersion(raw []byte) (int, error) {
+	var header map[string]json.RawMessage
+	err := json.Unmarshal(raw, &header)
+	if err != nil {
+		return 0, err
+	}
+
+	v, hasVersion := header["Version"]
+	_, hasSessions := header["Sessions"]
+	if !hasVersion || !hasSessions {
+		return 0, nil
+	}
+
+	var version int
+	err = json.Unmarshal(v, &version)
+	if err != nil {
+		return 0, nil
+	}
+
+	return version, nil
+}
+
+// writeSessions atomically replaces the sessions file, the caller must hold the exclusive lock
+func writeSessions(sessions map[string]SessionDef) error {
+	bytes, err := json.MarshalIndent(&sessionsFile{Version: SchemaVersion, Sessions: sessions}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal sessions: %w", err)
+	}
+
+	dir := filepath.Dir(sessionsPath())
+	tmp, err := os.CreateTemp(dir, SessionsFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't write sessions file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(bytes)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write sessions file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), sessionsPath())
+	if err != nil {
+		return fmt.Errorf("couldn't write sessions file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSessions returns all the sessions of the repository
+func LoadSessions() (map[string]SessionDef, error) {
+	unlock, err := lockSessions(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return readSessions()
+}
+
+// UpdateSessions runs fn on the sessions and saves them, the whole operation holds an exclusive lock,
+// nothing is saved if fn returns an error
+func UpdateSessions(fn func(sessions map[string]SessionDef) error) error {
+	unlock, err := lockSessions(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sessions, err := readSessions()
+	if err != nil {
+		return err
+	}
+
+	err = fn(sessions)
+	if err != nil {
+		return err
+	}
+
+	return writeSessions(sessions)
+}
+
+// UpdateSession runs fn on a single session and saves it, it does nothing if the session doesn't exist
+func UpdateSession(name string, fn func(s *SessionDef)) error {
+	return UpdateSessions(func(sessions map[string]SessionDef) error {
+		s, ok := sessions[name]
+		if !ok {
+			return nil
+		}
+
+		fn(&s)
+		sessions[name] = s
+		return nil
+	})
+}
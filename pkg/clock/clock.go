@@ -0,0 +1,22 @@
+package clock
+
+import "time"
+
+// Clock tells the time and waits, time based events use one so that they can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type system struct{}
+
+// System is the clock of the machine
+var System Clock = system{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -1,50 +1,169 @@
 package config
 
 import (
-	"log"
-
-	"github.com/spf13/viper"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
 )
 
+const ModeBranch string = "branch"
+const ModeShadow string = "shadow"
+
+const PolicyFatal string = "fatal"
+const PolicyPause string = "pause"
+const PolicyFollow string = "follow"
+
+// CfgIdentity overrides the user.name and user.email of the git config in commits
+type CfgIdentity struct {
+	Name  string `mapstructure:"name"`
+	Email string `mapstructure:"email"`
+}
+
 type CfgGit struct {
-	AutoAdd bool `mapstructure:"auto-add"`
+	AutoAdd        bool         `mapstructure:"auto-add"`
+	SnapshotAll    bool         `mapstructure:"snapshot-all"`
+	Mode           string       `mapstructure:"mode"`
+	OnBranchChange string       `mapstructure:"on-branch-change"`
+	Identity       *CfgIdentity `mapstructure:"identity"`
+}
+
+// CfgMessages are the Go templates of commit messages
+type CfgMessages struct {
+	Snapshot string `mapstructure:"snapshot"`
+	Squash   string `mapstructure:"squash"`
 }
 
-type CfgPeriodic struct {
-	Period int      `mapstructure:"period"`
-	Files  []string `mapstructure:"files"`
+// CfgEvent is a generic event entry, its options are decoded by the event type registered under Type
+type CfgEvent struct {
+	Type    string                 `mapstructure:"type"`
+	Name    string                 `mapstructure:"name"`
+	Options map[string]interface{} `mapstructure:",remain"`
+
+	// Where the entry is written ("file:line"), to point at it in errors
+	Source string `mapstructure:"-" json:"-"`
 }
 
-type CfgSave struct {
-	Files []string `mapstructure:"files"`
+// CfgRetentionRule keeps one snapshot per Every (all of them if zero) among the ones younger than Within
+// (whatever their age if zero)
+type CfgRetentionRule struct {
+	Within time.Duration `mapstructure:"within"`
+	Every  time.Duration `mapstructure:"every"`
 }
 
-type CfgEvents struct {
-	Periodic *CfgPeriodic `mapstructure:"periodic"`
-	Save     *CfgSave     `mapstructure:"save"`
+type CfgRetention struct {
+	// The first rule matching the age of a snapshot applies, snapshots matching none are dropped
+	Rules []CfgRetentionRule `mapstructure:"rules"`
+
+	// How often running sessions are compacted, never if zero
+	AutoCompact time.Duration `mapstructure:"auto-compact"`
+}
+
+// CfgBackup pushes the history of sessions to a remote, or a local mirror created if needed
+type CfgBackup struct {
+	// A configured remote, an url or the path of a local bare repository
+	Remote string `mapstructure:"remote"`
+
+	// Push periodically, and/or after that many snapshots
+	Every time.Duration `mapstructure:"every"`
+	After int           `mapstructure:"after"`
+
+	// How many times a failed push is retried, waiting twice as long each time
+	Retries int           `mapstructure:"retries"`
+	Backoff time.Duration `mapstructure:"backoff"`
 }
 
 type CfgRoot struct {
-	Events *CfgEvents `mapstructure:"events"`
-	Git    *CfgGit    `mapstructure:"git"`
+	Events    []CfgEvent    `mapstructure:"events"`
+	Git       *CfgGit       `mapstructure:"git"`
+	Messages  *CfgMessages  `mapstructure:"messages"`
+	Retention *CfgRetention `mapstructure:"retention"`
+	Backup    *CfgBackup    `mapstructure:"backup"`
 }
 
 var Cfg CfgRoot
 
-func Load() {
-	viper.SetConfigName("chrono")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+// eventsHook accepts the other ways events can be written, and turns them into a list of CfgEvent:
+//
+//	events:                      events:
+//	  periodic:                    - periodic:
+//	    period: 10                     period: 10
+func eventsHook(from reflect.Value, to reflect.Value) (interface{}, error) {
+	if to.Type() != reflect.TypeOf([]CfgEvent{}) {
+		return from.Interface(), nil
+	}
+
+	switch events := from.Interface().(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(events))
+		for k := range events {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
 
-	err := viper.ReadInConfig()
+		list := make([]interface{}, 0, len(events))
+		for _, k := range keys {
+			entry, err := typedEntry(k, events[k])
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, entry)
+		}
+		return list, nil
 
-	if err != nil {
-		log.Fatalf("Fatal error: couldn't load config file: %v", err.Error())
+	case []interface{}:
+		list := make([]interface{}, 0, len(events))
+		for _, e := range events {
+			m, ok := toStringMap(e)
+			if !ok {
+				return nil, fmt.Errorf("invalid event %v", e)
+			}
+
+			if _, hasType := m["type"]; !hasType && len(m) == 1 {
+				for k, v := range m {
+					entry, err := typedEntry(k, v)
+					if err != nil {
+						return nil, err
+					}
+					m = entry
+				}
+			}
+
+			list = append(list, m)
+		}
+		return list, nil
 	}
 
-	err = viper.Unmarshal(&Cfg)
+	return from.Interface(), nil
+}
 
-	if err != nil {
-		log.Fatalf("Fatal error: %v", err.Error())
+func typedEntry(eventType string, options interface{}) (map[string]interface{}, error) {
+	entry := map[string]interface{}{}
+	if options != nil {
+		m, ok := toStringMap(options)
+		if !ok {
+			return nil, fmt.Errorf("invalid options for event %v", eventType)
+		}
+		for k, v := range m {
+			entry[k] = v
+		}
 	}
+
+	entry["type"] = eventType
+	return entry, nil
+}
+
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[fmt.Sprint(k)] = v
+		}
+		return res, true
+	}
+
+	return nil, false
 }
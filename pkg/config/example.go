@@ -0,0 +1,28 @@
+package config
+
+// Example is the config file written by `chrono config init`
+const Example = `# Chrono config, see https://github.com/hazyuun/Chrono#config-file
+# Settings can also be given in ~/.config/chrono/config.yaml, CHRONO_* environment variables or with --set
+
+# Events when to automatically commit
+events:
+    # Commit the saved files once no file was saved for 2 seconds
+    - save:
+        files: ["."]
+        debounce: 2s
+
+    # Commit everything that changed every 10 minutes
+    - periodic:
+        period: 10m
+        files: ["."]
+
+git:
+    # When true, untracked files will automatically be added
+    auto-add: true
+
+    # "branch" commits to a chrono/<name> branch, "shadow" to refs/chrono/<name> without checking anything out
+    mode: branch
+
+    # "fatal", "pause" or "follow" when another branch gets checked out while a session is running
+    on-branch-change: pause
+`
@@ -0,0 +1,372 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// The config files of a repository, looked up at its root
+var RepositoryFileNames = []string{"chrono.yaml", "chrono.yml"}
+
+// Environment variables override settings, CHRONO_GIT_AUTO_ADD for git.auto-add for instance
+const EnvPrefix = "CHRONO"
+
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// EnvVar is the environment variable overriding a setting
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(envReplacer.Replace(key))
+}
+
+var ErrUnknownSetting = errors.New("unknown setting")
+
+var defaults = map[string]interface{}{
+	"git.auto-add":         false,
+	"git.snapshot-all":     false,
+	"git.mode":             ModeBranch,
+	"git.on-branch-change": PolicyFatal,
+	"messages.snapshot":    "{{.Message}}",
+	"messages.squash":      "{{.Message}}",
+}
+
+// Layers the config was loaded from, by increasing priority
+var Sources []string
+
+// Settings is the merged config, as it would be written in a config file
+var Settings map[string]interface{}
+
+var overrides = map[string]string{}
+
+// SetOverrides sets the key=value settings given on the command line, they take precedence over anything else
+func SetOverrides(values []string) error {
+	keys := settingKeys()
+
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q, expected key=value", value)
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, known := keys[key]; !known {
+			return fmt.Errorf("%w %q", ErrUnknownSetting, key)
+		}
+
+		overrides[key] = val
+	}
+
+	return nil
+}
+
+// UserFile is the config file applying to every repository of the user
+func UserFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "chrono", "config.yaml")
+}
+
+// RepositoryFile returns the config file of the repository at root, or the default name if there is none
+func RepositoryFile(root string) (string, bool) {
+	for _, name := range RepositoryFileNames {
+		path := filepath.Join(root, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+
+	return filepath.Join(root, RepositoryFileNames[0]), false
+}
+
+// Loaded is a merged config along with what it was merged from
+type Loaded struct {
+	Cfg      CfgRoot
+	Settings map[string]interface{}
+	Sources  []string
+}
+
+// Load merges the built-in defaults, the user config file, the config file of the repository at root,
+// CHRONO_* environment variables and the command line overrides, none of them being mandatory
+func Load(root string) error {
+	loaded, err := Read(root)
+	if err != nil {
+		return err
+	}
+
+	Use(loaded)
+	return nil
+}
+
+// Use makes loaded the config in use
+func Use(loaded Loaded) {
+	Cfg = loaded.Cfg
+	Settings = loaded.Settings
+	Sources = loaded.Sources
+}
+
+// Read merges and validates the config like Load, without using it
+func Read(root string) (Loaded, error) {
+	var cfg CfgRoot
+
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	sources := []string{"defaults"}
+
+	var events []string
+	for _, path := range Files(root) {
+		settings, positions, err := readFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Loaded{}, err
+		}
+
+		err = v.MergeConfigMap(settings)
+		if err != nil {
+			return Loaded{}, fmt.Errorf("%v: %w", path, err)
+		}
+
+		if _, ok := settings["events"]; ok {
+			events = positions
+		}
+		sources = append(sources, path)
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(envReplacer)
+	env := false
+	for key := range settingKeys() {
+		err := v.BindEnv(key)
+		if err != nil {
+			return Loaded{}, err
+		}
+
+		if _, ok := os.LookupEnv(EnvVar(key)); ok {
+			env = true
+		}
+	}
+	if env {
+		sources = append(sources, "environment")
+	}
+
+	for key, value := range overrides {
+		v.Set(key, value)
+	}
+	if len(overrides) > 0 {
+		sources = append(sources, "command line")
+	}
+
+	err := v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		eventsHook,
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
+	if err != nil {
+		return Loaded{}, err
+	}
+
+	err = check(&cfg)
+	if err != nil {
+		return Loaded{}, err
+	}
+
+	if len(events) == len(cfg.Events) {
+		for i := range cfg.Events {
+			cfg.Events[i].Source = events[i]
+		}
+	}
+
+	for i := range cfg.Events {
+		resolveFiles(&cfg.Events[i], root)
+	}
+
+	return Loaded{Cfg: cfg, Settings: v.AllSettings(), Sources: sources}, nil
+}
+
+// Files lists the config files that may apply to the repository at root, by increasing priority
+func Files(root string) []string {
+	files := []string{}
+	if path := UserFile(); path != "" {
+		files = append(files, path)
+	}
+
+	path, _ := RepositoryFile(root)
+	return append(files, path)
+}
+
+// IsFile tells whether path is one of the config files of the repository at root
+func IsFile(root string, path string) bool {
+	path = filepath.Clean(path)
+	if path == filepath.Clean(UserFile()) {
+		return true
+	}
+
+	for _, name := range RepositoryFileNames {
+		if path == filepath.Join(root, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveFiles makes the files of an event absolute, relative ones being relative to the root of the
+// repository wherever chrono is run from
+func resolveFiles(e *CfgEvent, root string) {
+	var files []string
+	switch v := e.Options["files"].(type) {
+	case string:
+		files = strings.Split(v, ",")
+	case []string:
+		files = v
+	case []interface{}:
+		for _, f := range v {
+			files = append(files, fmt.Sprint(f))
+		}
+	default:
+		// Missing, or invalid which the event reports when decoding it
+		return
+	}
+
+	abs := make([]string, 0, len(files))
+	for _, f := range files {
+		f = strings.TrimSpace(f)
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(root, f)
+		}
+		abs = append(abs, f)
+	}
+
+	options := make(map[string]interface{}, len(e.Options))
+	for k, v := range e.Options {
+		options[k] = v
+	}
+	options["files"] = abs
+	e.Options = options
+}
+
+// readFile parses and validates a config file, it also returns the position of each of its events
+func readFile(path string) (map[string]interface{}, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	positions, err := Validate(path, &doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings := map[string]interface{}{}
+	err = doc.Decode(&settings)
+	if err != nil && len(doc.Content) > 0 {
+		return nil, nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return settings, positions, nil
+}
+
+// check validates what can only be checked once every layer is merged, environment variables
+// and overrides included
+func check(cfg *CfgRoot) error {
+	var errs Errors
+
+	if cfg.Git != nil {
+		if cfg.Git.Mode != ModeBranch && cfg.Git.Mode != ModeShadow {
+			errs = append(errs, fmt.Errorf("git.mode must be %q or %q, not %q", ModeBranch, ModeShadow, cfg.Git.Mode))
+		}
+
+		switch cfg.Git.OnBranchChange {
+		case PolicyFatal, PolicyPause, PolicyFollow:
+		default:
+			errs = append(errs, fmt.Errorf("git.on-branch-change must be %q, %q or %q, not %q",
+				PolicyFatal, PolicyPause, PolicyFollow, cfg.Git.OnBranchChange))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// settingKeys lists the keys of every single valued setting, lists and events left aside
+func settingKeys() map[string]reflect.Type {
+	keys := map[string]reflect.Type{}
+
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := tagName(f)
+			if name == "" {
+				continue
+			}
+
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+
+			switch {
+			case ft.Kind() == reflect.Struct:
+				walk(prefix+name+".", ft)
+			case ft.Kind() == reflect.Slice || ft.Kind() == reflect.Map:
+			default:
+				keys[prefix+name] = ft
+			}
+		}
+	}
+	walk("", reflect.TypeOf(CfgRoot{}))
+
+	return keys
+}
+
+func tagName(f reflect.StructField) string {
+	tag := f.Tag.Get("mapstructure")
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return ""
+	}
+
+	return name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Errors gathers every problem found in a config, so that they can all be fixed at once
+type Errors []error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+type validator struct {
+	file   string
+	errs   Errors
+	events []string
+}
+
+func (v *validator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%v:%v: %v", v.file, node.Line, fmt.Sprintf(format, args...)))
+}
+
+// Validate checks a parsed config file against the config schema, errors point at lines of file,
+// the positions of its event entries are returned in the order they are decoded
+func Validate(file string, doc *yaml.Node) ([]string, error) {
+	v := &validator{file: file}
+
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		v.walk(doc.Content[0], reflect.TypeOf(CfgRoot{}), "")
+	}
+
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	return v.events, nil
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if isNull(node) {
+		return
+	}
+
+	switch {
+	case t == reflect.TypeOf([]CfgEvent{}):
+		v.walkEvents(node)
+
+	case t == durationType:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(node, "%v must be a duration like 30s or 5m", path)
+			return
+		}
+
+		var n int64
+		if node.Decode(&n) == nil {
+			if n < 0 {
+				v.errorf(node, "%v must not be negative", path)
+			}
+			return
+		}
+
+		d, err := time.ParseDuration(node.Value)
+		if err != nil {
+			v.errorf(node, "%v must be a duration like 30s or 5m, not %q", path, node.Value)
+		} else if d < 0 {
+			v.errorf(node, "%v must not be negative", path)
+		}
+
+	case t.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, "%v must be a map", name(path))
+			return
+		}
+
+		fields := map[string]reflect.StructField{}
+		for i := 0; i < t.NumField(); i++ {
+			if n := tagName(t.Field(i)); n != "" {
+				fields[n] = t.Field(i)
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			f, ok := fields[key.Value]
+			if !ok {
+				v.errorf(key, "unknown key %q in %v, expected one of %v",
+					key.Value, name(path), strings.Join(sortedKeys(fields), ", "))
+				continue
+			}
+
+			v.walk(value, f.Type, join(path, key.Value))
+		}
+
+	case t.Kind() == reflect.Slice:
+		if node.Kind == yaml.ScalarNode && t.Elem().Kind() == reflect.String {
+			return
+		}
+
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(node, "%v must be a list", path)
+			return
+		}
+
+		for _, item := range node.Content {
+			v.walk(item, t.Elem(), path+"[]")
+		}
+
+	case t.Kind() == reflect.Bool:
+		var b bool
+		if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+			v.errorf(node, "%v must be true or false", path)
+		}
+
+	case t.Kind() == reflect.Int:
+		var n int
+		if node.Kind != yaml.ScalarNode || node.Decode(&n) != nil {
+			v.errorf(node, "%v must be a number", path)
+		} else if n < 0 {
+			v.errorf(node, "%v must not be negative", path)
+		}
+
+	case t.Kind() == reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			v.errorf(node, "%v must be a string", path)
+		}
+	}
+}
+
+// walkEvents checks the shape of the events, their options are checked by their own type
+func (v *validator) walkEvents(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		entries := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if _, ok := entries[key.Value]; ok {
+				v.errorf(key, "event %q is given twice, use the list form to have several events of the same type", key.Value)
+			}
+			entries[key.Value] = key
+			v.walkOptions(value, key.Value)
+		}
+
+		for _, key := range sortedKeys(entries) {
+			v.events = append(v.events, fmt.Sprintf("%v:%v", v.file, entries[key].Line))
+		}
+
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			v.events = append(v.events, fmt.Sprintf("%v:%v", v.file, item.Line))
+
+			if item.Kind != yaml.MappingNode {
+				v.errorf(item, "an event must be a map")
+				continue
+			}
+
+			if len(item.Content) == 2 && item.Content[0].Value != "type" {
+				v.walkOptions(item.Content[1], item.Content[0].Value)
+				continue
+			}
+
+			typed := false
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				key, value := item.Content[i], item.Content[i+1]
+				if key.Value == "type" || key.Value == "name" {
+					if value.Kind != yaml.ScalarNode || value.Value == "" {
+						v.errorf(value, "event %v must be a non empty string", key.Value)
+					}
+					typed = typed || key.Value == "type"
+				}
+			}
+
+			if !typed {
+				v.errorf(item, "event has no type, write it either as \"- <type>: {...}\" or with a \"type\" key")
+			}
+		}
+
+	default:
+		v.errorf(node, "events must be a list or a map")
+	}
+}
+
+func (v *validator) walkOptions(node *yaml.Node, eventType string) {
+	if !isNull(node) && node.Kind != yaml.MappingNode {
+		v.errorf(node, "the options of event %v must be a map", eventType)
+	}
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func name(path string) string {
+	if path == "" {
+		return "the config"
+	}
+
+	return path
+}
@@ -0,0 +1,130 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Request struct {
+	Command string            `json:"Command"`
+	Args    map[string]string `json:"Args,omitempty"`
+}
+
+type Response struct {
+	OK      bool   `json:"OK"`
+	Message string `json:"Message,omitempty"`
+	Error   string `json:"Error,omitempty"`
+}
+
+type Handler func(req Request) Response
+
+// Server listens on a local unix socket and dispatches requests of running sessions to their handlers
+type Server struct {
+	listener net.Listener
+	handlers map[string]Handler
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+func Listen(path string) (*Server, error) {
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		listener: l,
+		handlers: make(map[string]Handler),
+	}, nil
+}
+
+func (s *Server) Handle(command string, handler Handler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.handlers[command] = handler
+}
+
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	var req Request
+	err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req)
+	if err != nil {
+		log.Error().Err(err).Msg("Control: invalid request")
+		return
+	}
+
+	s.mutex.Lock()
+	handler, ok := s.handlers[req.Command]
+	s.mutex.Unlock()
+
+	res := Response{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	if ok {
+		log.Info().Str("command", req.Command).Msg("Control: request")
+		res = handler(req)
+	}
+
+	err = json.NewEncoder(conn).Encode(&res)
+	if err != nil {
+		log.Error().Err(err).Msg("Control: couldn't send response")
+	}
+}
+
+// Close stops accepting requests and waits for the ones being handled
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func Send(path string, req Request, timeout time.Duration) (Response, error) {
+	var res Response
+
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	err = json.NewEncoder(conn).Encode(&req)
+	if err != nil {
+		return res, err
+	}
+
+	err = json.NewDecoder(conn).Decode(&res)
+	if err != nil {
+		return res, err
+	}
+
+	if !res.OK {
+		return res, fmt.Errorf("%v", res.Error)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,199 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedules never looked further than that
+const maxLookahead = 5 * 366 * 24 * time.Hour
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Shorthands for common schedules
+var shorthands = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule is a parsed cron expression: minute, hour, day of month, month and day of week,
+// each of them being *, a number, a range (1-5), a list (1,3,5) or a step (*/15, 9-18/2)
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Like cron, when both days are restricted (they don't start with *), a day matching either of them matches
+	domStar, dowStar bool
+}
+
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shorthands[expr]; ok {
+		expr = s
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w %q: expected %v fields (minute hour day-of-month month day-of-week), got %v",
+			ErrInvalidSchedule, expr, len(fields), len(parts))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, expr, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(expr string, f field) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %v", stepStr, f.name)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*":
+			lo, hi = f.min, f.max
+
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			lo, err = strconv.Atoi(a)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %v %q", f.name, a)
+			}
+			hi, err = strconv.Atoi(b)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %v %q", f.name, b)
+			}
+
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %v %q", f.name, rng)
+			}
+			lo, hi = n, n
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%v %q out of range %v-%v", f.name, rng, f.min, f.max)
+		}
+
+		for n := lo; n <= hi; n += step {
+			set |= 1 << n
+		}
+	}
+
+	return set, nil
+}
+
+func has(set uint64, n int) bool {
+	return set&(1<<n) != 0
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+
+	if !s.domStar && !s.dowStar {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// Next returns the first time matching the schedule strictly after t, or the zero time if it never matches
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+
+	// Wall clock times are walked in UTC, which has no DST, so that each step moves forward,
+	// and converted back once they match
+	w := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := w.Add(maxLookahead)
+
+	for w.Before(limit) {
+		if !has(s.month, int(w.Month())) {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !s.matchDay(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !has(s.hour, w.Hour()) {
+			w = w.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if !has(s.minute, w.Minute()) {
+			w = w.Add(time.Minute)
+			continue
+		}
+
+		// A time skipped by DST moves forward by the length of the gap, and one repeated by it
+		// matches its first occurrence only
+		next := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+		if wall := time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), 0, 0, time.UTC); wall.Before(w) {
+			next = next.Add(w.Sub(wall))
+		}
+
+		if next.After(t) {
+			return next
+		}
+
+		w = w.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,150 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustParse(t *testing.T, expr string) *Schedule {
+	t.Helper()
+
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+
+	return s
+}
+
+// expectNext checks the successive times a schedule matches after start
+func expectNext(t *testing.T, expr string, start time.Time, want ...time.Time) {
+	t.Helper()
+
+	s := mustParse(t, expr)
+	next := start
+	for i, w := range want {
+		next = s.Next(next)
+		if !next.Equal(w) {
+			t.Fatalf("%q: match #%v after %v is %v, want %v", expr, i+1, start, next, w)
+		}
+	}
+}
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+func TestDSTGap(t *testing.T) {
+	ny := newYork(t)
+
+	// 2027-03-14 02:00 EST jumps to 03:00 EDT
+	expectNext(t, "0 9-18 * * *", time.Date(2027, 3, 13, 19, 0, 0, 0, ny),
+		time.Date(2027, 3, 14, 9, 0, 0, 0, ny),
+		time.Date(2027, 3, 14, 10, 0, 0, 0, ny),
+	)
+
+	// 02:30 doesn't exist that day, it is moved forward
+	expectNext(t, "30 2 * * *", time.Date(2027, 3, 13, 12, 0, 0, 0, ny),
+		time.Date(2027, 3, 14, 7, 30, 0, 0, time.UTC),
+		time.Date(2027, 3, 15, 2, 30, 0, 0, ny),
+	)
+
+	expectNext(t, "*/30 * * * *", time.Date(2027, 3, 14, 1, 45, 0, 0, ny),
+		time.Date(2027, 3, 14, 7, 0, 0, 0, time.UTC),
+		time.Date(2027, 3, 14, 7, 30, 0, 0, time.UTC),
+	)
+}
+
+func TestDSTOverlap(t *testing.T) {
+	ny := newYork(t)
+
+	// 2027-11-07 02:00 EDT goes back to 01:00 EST, 01:30 only matches once
+	expectNext(t, "30 1 * * *", time.Date(2027, 11, 7, 0, 0, 0, 0, ny),
+		time.Date(2027, 11, 7, 5, 30, 0, 0, time.UTC),
+		time.Date(2027, 11, 8, 1, 30, 0, 0, ny),
+	)
+
+	// Starting during the repeated hour still moves forward
+	expectNext(t, "0 * * * *", time.Date(2027, 11, 7, 6, 15, 0, 0, time.UTC),
+		time.Date(2027, 11, 7, 7, 0, 0, 0, time.UTC),
+		time.Date(2027, 11, 7, 8, 0, 0, 0, time.UTC),
+	)
+}
+
+func TestSteps(t *testing.T) {
+	start := time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC)
+
+	expectNext(t, "*/20 * * * *", start,
+		time.Date(2026, 10, 16, 10, 20, 0, 0, time.UTC),
+		time.Date(2026, 10, 16, 10, 40, 0, 0, time.UTC),
+		time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC),
+	)
+
+	expectNext(t, "0 9-17/4 * * *", start,
+		time.Date(2026, 10, 16, 13, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 16, 17, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC),
+	)
+}
+
+func TestShorthands(t *testing.T) {
+	expectNext(t, "@daily", time.Date(2026, 10, 16, 10, 5, 0, 0, time.UTC),
+		time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+	)
+}
+
+func TestDays(t *testing.T) {
+	// Friday
+	start := time.Date(2026, 10, 16, 13, 0, 0, 0, time.UTC)
+
+	// Either the 1st of the month or a Monday
+	expectNext(t, "0 12 1 * 1", start,
+		time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 12, 0, 0, 0, time.UTC),
+	)
+
+	// A day of month starting with * isn't a restriction, only Mondays match
+	expectNext(t, "0 12 */1 * 1", start,
+		time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 12, 0, 0, 0, time.UTC),
+	)
+
+	// Sunday is both 0 and 7
+	expectNext(t, "0 0 * * 7", start,
+		time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+	)
+}
+
+func TestFebruary29(t *testing.T) {
+	expectNext(t, "0 0 29 2 *", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		time.Date(2032, 2, 29, 0, 0, 0, 0, time.UTC),
+	)
+}
+
+func TestNever(t *testing.T) {
+	s := mustParse(t, "0 0 30 2 *")
+	if next := s.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Fatalf("expected no match, got %v", next)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "x * * * *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should fail", expr)
+		}
+	}
+}
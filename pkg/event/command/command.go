@@ -0,0 +1,189 @@
+package command
+
+import (
+	"bytes"
+	"chrono/pkg/chrono"
+	"chrono/pkg/event/event"
+	"chrono/pkg/scheduler"
+	"chrono/pkg/watcher"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CommitAlways    = "always"
+	CommitOnSuccess = "success"
+	CommitOnFailure = "failure"
+)
+
+// How many bytes of the output of a failed command get logged at most
+const maxLoggedOutput = 4096
+
+type Config struct {
+	Command  string        `mapstructure:"command"`
+	Files    []string      `mapstructure:"files"`
+	Debounce time.Duration `mapstructure:"debounce"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Success  string        `mapstructure:"success"`
+	Failure  string        `mapstructure:"failure"`
+	CommitOn string        `mapstructure:"commit-on"`
+}
+
+// CommandEvent runs a command once files are saved, and commits with a message telling whether it succeeded
+type CommandEvent struct {
+	name string
+	cfg  Config
+	w    *watcher.Watcher
+	ctx  context.Context
+}
+
+type result struct {
+	paths []string
+	err   error
+}
+
+func init() {
+	event.Register(event.Type{
+		Name:   "command",
+		Decode: decode,
+		New:    New,
+	})
+}
+
+func decode(options map[string]interface{}) (interface{}, error) {
+	cfg := Config{
+		Debounce: 2 * time.Second,
+		Success:  "succeeded",
+		Failure:  "failed",
+		CommitOn: CommitAlways,
+	}
+
+	err := event.DecodeOptions(options, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(cfg.Command) == "" {
+		return nil, errors.New("command must not be empty")
+	}
+
+	if len(cfg.Files) == 0 {
+		return nil, errors.New("files must not be empty")
+	}
+
+	switch cfg.CommitOn {
+	case CommitAlways, CommitOnSuccess, CommitOnFailure:
+	default:
+		return nil, fmt.Errorf("commit-on must be one of %v, %v or %v",
+			CommitAlways, CommitOnSuccess, CommitOnFailure)
+	}
+
+	return cfg, nil
+}
+
+func New(name string, cfg interface{}) (event.Event, error) {
+	return &CommandEvent{
+		name: name,
+		cfg:  cfg.(Config),
+	}, nil
+}
+
+func (e *CommandEvent) Paths() []string {
+	return e.cfg.Files
+}
+
+func (e *CommandEvent) Init(ctx context.Context) error {
+	log.Info().
+		Str("name", e.name).
+		Str("command", e.cfg.Command).
+		Strs("files", e.cfg.Files).
+		Dur("debounce", e.cfg.Debounce).
+		Msg("Initializing Command")
+
+	var err error
+	e.w, err = watcher.New(chrono.RootPath, e.cfg.Files)
+	if err != nil {
+		return err
+	}
+
+	e.ctx = ctx
+
+	return nil
+}
+
+// Watch runs the command once no file was saved for the debounce period, files saved
+// while it runs are kept and the command runs again for them once it finished
+func (e *CommandEvent) Watch() error {
+	return e.w.Debounce(e.ctx, e.cfg.Debounce, 0, func(paths []string) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e.notify(result{paths: paths, err: e.run()})
+		}()
+		return done
+	})
+}
+
+func (e *CommandEvent) run() error {
+	ctx := e.ctx
+	if e.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.cfg.Timeout)
+		defer cancel()
+	}
+
+	log.Info().Str("name", e.name).Str("command", e.cfg.Command).Msg("Running command")
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.cfg.Command)
+	cmd.Dir = chrono.RootPath
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err != nil {
+		out := output.String()
+		if len(out) > maxLoggedOutput {
+			out = out[len(out)-maxLoggedOutput:]
+		}
+		log.Warn().Str("name", e.name).Err(err).Str("output", out).Msg("Command failed")
+	}
+
+	return err
+}
+
+func (e *CommandEvent) notify(res result) {
+	// Don't commit a failure caused by the session being stopped
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	marker := e.cfg.Success
+	if res.err != nil {
+		marker = e.cfg.Failure
+	}
+
+	if (e.cfg.CommitOn == CommitOnSuccess && res.err != nil) ||
+		(e.cfg.CommitOn == CommitOnFailure && res.err == nil) {
+		log.Info().Str("name", e.name).Str("result", marker).Msg("Skipped commit")
+		return
+	}
+
+	scheduler.Notify(scheduler.SchedulerMessage{
+		Sender: "Command",
+		Message: fmt.Sprintf("[Command] %v %v: %v %v",
+			marker, e.cfg.Command, watcher.ListFiles(chrono.RootPath, res.paths), time.Now().Format("15:04:05 02/01/2006")),
+		Paths: res.paths,
+	})
+}
+
+func (e *CommandEvent) Fini() error {
+	log.Info().Str("name", e.name).Msg("Command stopped")
+	return e.w.Close()
+}
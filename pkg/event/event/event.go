@@ -7,3 +7,10 @@ type Event interface {
 	Watch() error
 	Fini() error
 }
+
+// FileEvent is implemented by events that commit a given set of files,
+// those are committed one last time when the session stops
+type FileEvent interface {
+	Event
+	Paths() []string
+}
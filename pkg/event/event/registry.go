@@ -0,0 +1,215 @@
+package event
+
+import (
+	"chrono/pkg/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Decoder turns the raw options of an event entry into the config of its type
+type Decoder func(options map[string]interface{}) (interface{}, error)
+
+// Constructor creates an instance of an event type from its decoded config
+type Constructor func(name string, cfg interface{}) (Event, error)
+
+type Type struct {
+	Name   string
+	Decode Decoder
+	New    Constructor
+}
+
+var registry = struct {
+	types map[string]Type
+	mutex sync.Mutex
+}{
+	types: make(map[string]Type),
+}
+
+// Register makes an event type available to the config, it is meant to be called from init()
+func Register(t Type) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if _, ok := registry.types[t.Name]; ok {
+		panic(fmt.Sprintf("event type %v registered twice", t.Name))
+	}
+
+	registry.types[t.Name] = t
+}
+
+func Lookup(name string) (Type, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	t, ok := registry.types[name]
+	return t, ok
+}
+
+// Types lists the names of the registered event types
+func Types() []string {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	names := make([]string, 0, len(registry.types))
+	for name := range registry.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DecodeOptions decodes options into cfg, unknown options are rejected
+func DecodeOptions(options map[string]interface{}, cfg interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           cfg,
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(options)
+}
+
+// Entry is a configured event, its options decoded but the event not created yet
+type Entry struct {
+	Name   string
+	Type   string
+	Config interface{}
+
+	position string
+}
+
+// New creates the event instance of the entry
+func (entry Entry) New() (Event, error) {
+	t, ok := Lookup(entry.Type)
+	if !ok {
+		return nil, fmt.Errorf("%vunknown event type %q", entry.position, entry.Type)
+	}
+
+	e, err := t.New(entry.Name, entry.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%vevent %v: %w", entry.position, entry.Name, err)
+	}
+
+	return e, nil
+}
+
+// Entries decodes the options of every configured event, entries without a name are named
+// after their type, followed by a number if there are several of them
+func Entries(entries []config.CfgEvent) ([]Entry, error) {
+	decoded := make([]Entry, 0, len(entries))
+	count := make(map[string]int)
+	used := make(map[string]bool)
+
+	for i, entry := range entries {
+		t, ok := Lookup(entry.Type)
+		if !ok {
+			return nil, fmt.Errorf("%vunknown event type %q, available types are %v",
+				position(i, entry), entry.Type, strings.Join(Types(), ", "))
+		}
+
+		name := entry.Name
+		if name == "" {
+			count[entry.Type]++
+			name = entry.Type
+			if count[entry.Type] > 1 {
+				name = fmt.Sprintf("%v#%v", entry.Type, count[entry.Type])
+			}
+		}
+
+		if used[name] {
+			return nil, fmt.Errorf("%vthere is already an event named %q", position(i, entry), name)
+		}
+		used[name] = true
+
+		cfg, err := t.Decode(entry.Options)
+		if err != nil {
+			return nil, fmt.Errorf("%vevent %v: %w", position(i, entry), name, err)
+		}
+
+		decoded = append(decoded, Entry{
+			Name:     name,
+			Type:     entry.Type,
+			Config:   cfg,
+			position: position(i, entry),
+		})
+	}
+
+	return decoded, nil
+}
+
+// FromConfig creates an instance of every configured event
+func FromConfig(entries []config.CfgEvent) ([]Event, error) {
+	decoded, err := Entries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(decoded))
+	for _, entry := range decoded {
+		e, err := entry.New()
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// Check creates the configured events like FromConfig does, and also reports the files
+// they commit that don't exist, relative paths being relative to root
+func Check(entries []config.CfgEvent, root string) error {
+	events, err := FromConfig(entries)
+	if err != nil {
+		return err
+	}
+
+	var errs config.Errors
+	for i, e := range events {
+		fe, ok := e.(FileEvent)
+		if !ok {
+			continue
+		}
+
+		for _, path := range fe.Paths() {
+			full := path
+			if !filepath.IsAbs(full) {
+				full = filepath.Join(root, full)
+			}
+
+			if _, err := os.Stat(full); err != nil {
+				errs = append(errs, fmt.Errorf("%vfile %q doesn't exist", position(i, entries[i]), path))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// position tells where an event is configured, to prefix errors with
+func position(i int, entry config.CfgEvent) string {
+	if entry.Source != "" {
+		return entry.Source + ": "
+	}
+
+	return fmt.Sprintf("event #%v: ", i+1)
+}
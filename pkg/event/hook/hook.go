@@ -0,0 +1,149 @@
+package hook
+
+import (
+	"chrono/pkg/event/event"
+	"chrono/pkg/scheduler"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Supported are the git hooks chrono can be called from
+var Supported = []string{"pre-commit", "post-checkout", "pre-rebase"}
+
+type Config struct {
+	Hooks []string `mapstructure:"hooks"`
+	Files []string `mapstructure:"files"`
+}
+
+// HookEvent commits when one of the installed git hooks runs,
+// so that the working tree is saved before git touches it
+type HookEvent struct {
+	name string
+	cfg  Config
+	ctx  context.Context
+}
+
+// The running hook events, git hooks reach them through Trigger
+var running = struct {
+	events map[*HookEvent]bool
+	mutex  sync.Mutex
+}{
+	events: make(map[*HookEvent]bool),
+}
+
+func init() {
+	event.Register(event.Type{
+		Name:   "hook",
+		Decode: decode,
+		New:    New,
+	})
+}
+
+func IsSupported(hook string) bool {
+	for _, h := range Supported {
+		if h == hook {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decode(options map[string]interface{}) (interface{}, error) {
+	cfg := Config{Hooks: Supported}
+	err := event.DecodeOptions(options, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range cfg.Hooks {
+		if !IsSupported(h) {
+			return nil, fmt.Errorf("unsupported hook %q, supported hooks are %v", h, strings.Join(Supported, ", "))
+		}
+	}
+
+	return cfg, nil
+}
+
+func New(name string, cfg interface{}) (event.Event, error) {
+	return &HookEvent{
+		name: name,
+		cfg:  cfg.(Config),
+	}, nil
+}
+
+// Paths are the files committed when a hook runs, the whole working tree if empty
+func (e *HookEvent) Paths() []string {
+	return e.cfg.Files
+}
+
+func (e *HookEvent) Init(ctx context.Context) error {
+	log.Info().
+		Str("name", e.name).
+		Strs("hooks", e.cfg.Hooks).
+		Strs("files", e.cfg.Files).
+		Msg("Initializing Hook")
+
+	e.ctx = ctx
+
+	running.mutex.Lock()
+	running.events[e] = true
+	running.mutex.Unlock()
+
+	return nil
+}
+
+func (e *HookEvent) Watch() error {
+	<-e.ctx.Done()
+	return nil
+}
+
+func (e *HookEvent) handles(hook string) bool {
+	for _, h := range e.cfg.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Trigger commits for every running hook event handling the given git hook,
+// it returns once the commits are done so that git only goes on afterwards
+func Trigger(hook string) error {
+	running.mutex.Lock()
+	var events []*HookEvent
+	for e := range running.events {
+		if e.handles(hook) {
+			events = append(events, e)
+		}
+	}
+	running.mutex.Unlock()
+
+	for _, e := range events {
+		err := scheduler.NotifyWait(scheduler.SchedulerMessage{
+			Sender:  "Hook",
+			Message: fmt.Sprintf("[Hook] %v %v", hook, time.Now().Format("15:04:05 02/01/2006")),
+			Paths:   e.cfg.Files,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *HookEvent) Fini() error {
+	running.mutex.Lock()
+	delete(running.events, e)
+	running.mutex.Unlock()
+
+	log.Info().Str("name", e.name).Msg("Hook stopped")
+	return nil
+}
@@ -0,0 +1,103 @@
+package idle
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/event/event"
+	"chrono/pkg/scheduler"
+	"chrono/pkg/watcher"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultQuiet = 30 * time.Second
+
+type Config struct {
+	Files []string      `mapstructure:"files"`
+	Quiet time.Duration `mapstructure:"quiet"`
+}
+
+// IdleEvent commits once the files stopped changing for the quiet period
+type IdleEvent struct {
+	name string
+	cfg  Config
+	w    *watcher.Watcher
+	ctx  context.Context
+}
+
+func init() {
+	event.Register(event.Type{
+		Name:   "idle",
+		Decode: decode,
+		New:    New,
+	})
+}
+
+func decode(options map[string]interface{}) (interface{}, error) {
+	cfg := Config{Quiet: defaultQuiet}
+	err := event.DecodeOptions(options, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Files) == 0 {
+		return nil, errors.New("files must not be empty")
+	}
+
+	if cfg.Quiet <= 0 {
+		return nil, errors.New("quiet must be greater than 0")
+	}
+
+	return cfg, nil
+}
+
+func New(name string, cfg interface{}) (event.Event, error) {
+	return &IdleEvent{
+		name: name,
+		cfg:  cfg.(Config),
+	}, nil
+}
+
+func (e *IdleEvent) Paths() []string {
+	return e.cfg.Files
+}
+
+func (e *IdleEvent) Init(ctx context.Context) error {
+	log.Info().
+		Str("name", e.name).
+		Strs("files", e.cfg.Files).
+		Dur("quiet", e.cfg.Quiet).
+		Msg("Initializing Idle")
+
+	var err error
+	e.w, err = watcher.New(chrono.RootPath, e.cfg.Files)
+	if err != nil {
+		return err
+	}
+
+	e.ctx = ctx
+
+	return nil
+}
+
+// Watch notifies once no file changed for the quiet period after some activity,
+// nothing is notified while there is no activity at all
+func (e *IdleEvent) Watch() error {
+	return e.w.Debounce(e.ctx, e.cfg.Quiet, 0, func(paths []string) <-chan struct{} {
+		scheduler.Notify(scheduler.SchedulerMessage{
+			Sender: "Idle",
+			Message: fmt.Sprintf("[Idle] Updated %v after %v of inactivity %v",
+				watcher.ListFiles(chrono.RootPath, paths), e.cfg.Quiet, time.Now().Format("15:04:05 02/01/2006")),
+			Paths: paths,
+		})
+		return nil
+	})
+}
+
+func (e *IdleEvent) Fini() error {
+	log.Info().Str("name", e.name).Msg("Idle stopped")
+	return e.w.Close()
+}
@@ -1,55 +1,256 @@
 package periodic
 
 import (
-	"chrono/pkg/config"
+	"chrono/pkg/chrono"
+	"chrono/pkg/clock"
+	"chrono/pkg/cron"
+	"chrono/pkg/event/event"
 	"chrono/pkg/scheduler"
+	"chrono/pkg/watcher"
 	"context"
+	"errors"
 	"fmt"
-
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+type Config struct {
+	// A duration like 5m or 90s, a plain number being a number of seconds
+	Period time.Duration `mapstructure:"period"`
+
+	// A cron expression, instead of a period
+	Schedule string `mapstructure:"schedule"`
+
+	// Trigger on multiples of the period since midnight (xx:00, xx:15, ...) instead of since the start
+	Align bool `mapstructure:"align"`
+
+	// Don't trigger when no file event was seen since the last time, the content of the files isn't compared
+	SkipUnchanged bool `mapstructure:"skip-unchanged"`
+
+	Files []string `mapstructure:"files"`
+}
+
 type PeriodicEvent struct {
-	ticker *time.Ticker
-	ctx    context.Context
+	name     string
+	cfg      Config
+	clock    clock.Clock
+	schedule *cron.Schedule
+	w        *watcher.Watcher
+	ctx      context.Context
+
+	// Whether a file changed since the last time it triggered
+	changed atomic.Bool
+
+	notify func(scheduler.SchedulerMessage)
 }
 
-var Periodic PeriodicEvent
+func init() {
+	event.Register(event.Type{
+		Name:   "periodic",
+		Decode: decode,
+		New:    New,
+	})
+}
+
+func decode(options map[string]interface{}) (interface{}, error) {
+	options = secondsPeriod(options)
+
+	var cfg Config
+	err := event.DecodeOptions(options, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Schedule != "" {
+		if cfg.Period != 0 || cfg.Align {
+			return nil, errors.New("period and align can't be used along with schedule")
+		}
+
+		s, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("schedule %q never triggers", cfg.Schedule)
+		}
+
+		return cfg, nil
+	}
+
+	if cfg.Period <= 0 {
+		return nil, errors.New("period must be greater than 0, or a schedule must be given")
+	}
+
+	return cfg, nil
+}
+
+// secondsPeriod turns a period given as a plain number into seconds, as it used to be the only way to give it
+func secondsPeriod(options map[string]interface{}) map[string]interface{} {
+	var seconds float64
+	switch p := options["period"].(type) {
+	case int:
+		seconds = float64(p)
+	case int64:
+		seconds = float64(p)
+	case float64:
+		seconds = p
+	default:
+		return options
+	}
+
+	copied := make(map[string]interface{}, len(options))
+	for k, v := range options {
+		copied[k] = v
+	}
+	copied["period"] = time.Duration(seconds * float64(time.Second))
+
+	return copied
+}
+
+func New(name string, cfg interface{}) (event.Event, error) {
+	e, err := NewWithClock(name, cfg.(Config), clock.System)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// NewWithClock creates a periodic event driven by the given clock
+func NewWithClock(name string, cfg Config, c clock.Clock) (*PeriodicEvent, error) {
+	e := &PeriodicEvent{
+		name:   name,
+		cfg:    cfg,
+		clock:  c,
+		notify: scheduler.Notify,
+	}
+
+	if cfg.Schedule != "" {
+		s, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		e.schedule = s
+	}
+
+	return e, nil
+}
 
-var ticker *time.Ticker
+func (e *PeriodicEvent) Paths() []string {
+	return e.cfg.Files
+}
 
-func (event PeriodicEvent) Init(ctx context.Context) error {
+func (e *PeriodicEvent) Init(ctx context.Context) error {
 	log.Info().
-		Int("period", config.Cfg.Events.Periodic.Period).
-		Strs("files", config.Cfg.Events.Periodic.Files).
+		Str("name", e.name).
+		Dur("period", e.cfg.Period).
+		Str("schedule", e.cfg.Schedule).
+		Strs("files", e.cfg.Files).
 		Msg("Initializing Periodic")
 
-	Periodic.ticker = time.NewTicker(time.Duration(config.Cfg.Events.Periodic.Period) * time.Second)
-	Periodic.ctx = ctx
+	if e.cfg.SkipUnchanged {
+		var err error
+		e.w, err = watcher.New(chrono.RootPath, e.cfg.Files)
+		if err != nil {
+			return err
+		}
+	}
+
+	e.ctx = ctx
 	return nil
 }
 
-func (event PeriodicEvent) Watch() error {
+// Next returns when the event triggers after now, the zero time meaning never
+func (e *PeriodicEvent) Next(now time.Time) time.Time {
+	if e.schedule != nil {
+		return e.schedule.Next(now)
+	}
+
+	if e.cfg.Align {
+		return align(now, e.cfg.Period)
+	}
+
+	return now.Add(e.cfg.Period)
+}
+
+// align returns the next multiple of period since midnight, periods not dividing a day start over at midnight
+func align(now time.Time, period time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := midnight.AddDate(0, 0, 1)
+
+	next := midnight.Add((now.Sub(midnight)/period + 1) * period)
+	if next.After(tomorrow) {
+		return tomorrow
+	}
+
+	return next
+}
+
+func (e *PeriodicEvent) wait() <-chan time.Time {
+	now := e.clock.Now()
+
+	next := e.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+
+	return e.clock.After(next.Sub(now))
+}
+
+func (e *PeriodicEvent) Watch() error {
+	// Whether files changed before the session started isn't known, the first time always triggers
+	e.changed.Store(true)
+
+	// Files are only watched to skip unchanged ticks
+	var errs chan error
+	if e.w != nil {
+		errs = make(chan error, 1)
+		go func() {
+			errs <- e.w.Debounce(e.ctx, 0, 0, func(paths []string) <-chan struct{} {
+				e.changed.Store(true)
+				return nil
+			})
+		}()
+	}
+
+	tick := e.wait()
 	for {
 		select {
-		case <-Periodic.ctx.Done():
-			return nil
+		case <-e.ctx.Done():
+			if errs == nil {
+				return nil
+			}
+			return <-errs
+
+		case err := <-errs:
+			return err
+
+		case <-tick:
+			tick = e.wait()
 
-		case <-Periodic.ticker.C:
-			scheduler.Notify(scheduler.SchedulerMessage{
+			if e.cfg.SkipUnchanged && !e.changed.Swap(false) {
+				log.Debug().Str("name", e.name).Msg("Periodic skipped, nothing changed")
+				continue
+			}
+
+			e.notify(scheduler.SchedulerMessage{
 				Sender:  "Periodic",
-				Message: fmt.Sprintf("[Periodic] %v", time.Now().Format("15:04:05 02/01/2006")),
-				Paths:   config.Cfg.Events.Periodic.Files,
+				Message: fmt.Sprintf("[Periodic] %v", e.clock.Now().Format("15:04:05 02/01/2006")),
+				Paths:   e.cfg.Files,
 			})
 		}
 	}
-
-	return nil
 }
 
-func (event PeriodicEvent) Fini() error {
-	log.Info().Msg("Periodic stopped")
+func (e *PeriodicEvent) Fini() error {
+	log.Info().Str("name", e.name).Msg("Periodic stopped")
+
+	if e.w != nil {
+		return e.w.Close()
+	}
+
 	return nil
 }
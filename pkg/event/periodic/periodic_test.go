@@ -0,0 +1,262 @@
+package periodic
+
+import (
+	"chrono/pkg/chrono"
+	"chrono/pkg/scheduler"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to, every wait of the event is sent on waits and ends on fire
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	waits chan time.Duration
+	ticks chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{
+		now:   now,
+		waits: make(chan time.Duration, 1),
+		ticks: make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.ticks
+}
+
+// wait returns the duration the event waits for next
+func (c *fakeClock) wait(t *testing.T) time.Duration {
+	t.Helper()
+
+	select {
+	case d := <-c.waits:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event didn't wait for the clock")
+		return 0
+	}
+}
+
+// fire moves the clock to the end of the current wait, and returns the next wait once the tick was handled
+func (c *fakeClock) fire(t *testing.T, d time.Duration) time.Duration {
+	t.Helper()
+
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mutex.Unlock()
+
+	c.ticks <- now
+	return c.wait(t)
+}
+
+func at(hour int, min int) time.Time {
+	return time.Date(2027, 6, 1, hour, min, 0, 0, time.UTC)
+}
+
+func decodeConfig(t *testing.T, options map[string]interface{}) Config {
+	t.Helper()
+
+	cfg, err := decode(options)
+	if err != nil {
+		t.Fatalf("decode(%v): %v", options, err)
+	}
+
+	return cfg.(Config)
+}
+
+func newEvent(t *testing.T, cfg Config, c *fakeClock) *PeriodicEvent {
+	t.Helper()
+
+	e, err := NewWithClock("test", cfg, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func TestPeriod(t *testing.T) {
+	for _, period := range []interface{}{90, int64(90), 90.0, "90s", "1m30s"} {
+		cfg := decodeConfig(t, map[string]interface{}{"period": period})
+		if cfg.Period != 90*time.Second {
+			t.Errorf("period %#v is %v, want 1m30s", period, cfg.Period)
+		}
+	}
+
+	e := newEvent(t, Config{Period: 90 * time.Second}, newFakeClock(at(10, 7)))
+	if next := e.Next(at(10, 7)); !next.Equal(at(10, 7).Add(90 * time.Second)) {
+		t.Errorf("next is %v, want 90s later", next)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	cfg := decodeConfig(t, map[string]interface{}{"schedule": "0 * * * *"})
+
+	e := newEvent(t, cfg, newFakeClock(at(10, 7)))
+	if next := e.Next(at(10, 7)); !next.Equal(at(11, 0)) {
+		t.Errorf("next is %v, want %v", next, at(11, 0))
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"schedule": "0 * * * *", "period": "5m"},
+		{"schedule": "0 * * * *", "align": true},
+		{"schedule": "0 0 30 2 *"},
+		{},
+	} {
+		if _, err := decode(options); err == nil {
+			t.Errorf("decode(%v) succeeded", options)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	tests := []struct {
+		period time.Duration
+		now    time.Time
+		want   time.Time
+	}{
+		{15 * time.Minute, at(10, 7), at(10, 15)},
+		{15 * time.Minute, at(10, 15), at(10, 30)},
+		{time.Hour, at(23, 59), at(0, 0).AddDate(0, 0, 1)},
+		// 7 minutes don't divide a day, the periods start over at midnight
+		{7 * time.Minute, at(23, 58), at(0, 0).AddDate(0, 0, 1)},
+		{7 * time.Minute, at(0, 0), at(0, 7)},
+	}
+
+	for _, test := range tests {
+		e := newEvent(t, Config{Period: test.period, Align: true}, newFakeClock(test.now))
+		if next := e.Next(test.now); !next.Equal(test.want) {
+			t.Errorf("%v aligned after %v is %v, want %v", test.period, test.now, next, test.want)
+		}
+	}
+}
+
+// start runs the event until the test ends, the messages it sends are received on the returned channel
+func start(t *testing.T, e *PeriodicEvent) <-chan scheduler.SchedulerMessage {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sent := make(chan scheduler.SchedulerMessage)
+	e.notify = func(msg scheduler.SchedulerMessage) {
+		select {
+		case <-ctx.Done():
+		case sent <- msg:
+		}
+	}
+
+	err := e.Init(ctx)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Watch()
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		e.Fini()
+	})
+
+	return sent
+}
+
+func expectSent(t *testing.T, sent <-chan scheduler.SchedulerMessage) {
+	t.Helper()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent")
+	}
+}
+
+func expectNothing(t *testing.T, sent <-chan scheduler.SchedulerMessage) {
+	t.Helper()
+
+	select {
+	case msg := <-sent:
+		t.Fatalf("%q was sent", msg.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWaitAligned(t *testing.T) {
+	c := newFakeClock(at(10, 7))
+	e := newEvent(t, Config{Period: 15 * time.Minute, Align: true}, c)
+	sent := start(t, e)
+
+	if d := c.wait(t); d != 8*time.Minute {
+		t.Fatalf("first wait is %v, want 8m", d)
+	}
+
+	if d := c.fire(t, 8*time.Minute); d != 15*time.Minute {
+		t.Fatalf("second wait is %v, want 15m", d)
+	}
+	expectSent(t, sent)
+
+	if d := c.fire(t, 15*time.Minute); d != 15*time.Minute {
+		t.Fatalf("third wait is %v, want 15m", d)
+	}
+	expectSent(t, sent)
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	root := t.TempDir()
+	previous := chrono.RootPath
+	chrono.RootPath = root
+	t.Cleanup(func() { chrono.RootPath = previous })
+
+	c := newFakeClock(at(10, 0))
+	e := newEvent(t, Config{Period: time.Minute, SkipUnchanged: true, Files: []string{root}}, c)
+	sent := start(t, e)
+	c.wait(t)
+
+	// Nothing is known about the changes made before the session started
+	c.fire(t, time.Minute)
+	expectSent(t, sent)
+
+	c.fire(t, time.Minute)
+	expectNothing(t, sent)
+
+	err := os.WriteFile(filepath.Join(root, "file"), []byte("changed"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !e.changed.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("the change wasn't seen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.fire(t, time.Minute)
+	expectSent(t, sent)
+
+	c.fire(t, time.Minute)
+	expectNothing(t, sent)
+}
@@ -1,81 +1,98 @@
 package save
 
 import (
-	"chrono/pkg/config"
+	"chrono/pkg/chrono"
+	"chrono/pkg/event/event"
 	"chrono/pkg/scheduler"
+	"chrono/pkg/watcher"
 	"context"
+	"errors"
 	"time"
 
 	"fmt"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 )
 
+type Config struct {
+	Files    []string      `mapstructure:"files"`
+	Debounce time.Duration `mapstructure:"debounce"`
+	MaxWait  time.Duration `mapstructure:"max-wait"`
+}
+
 type SaveEvent struct {
-	ctx context.Context
+	name string
+	cfg  Config
+	w    *watcher.Watcher
+	ctx  context.Context
 }
 
-var Save SaveEvent
+func init() {
+	event.Register(event.Type{
+		Name:   "save",
+		Decode: decode,
+		New:    New,
+	})
+}
+
+func decode(options map[string]interface{}) (interface{}, error) {
+	var cfg Config
+	err := event.DecodeOptions(options, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Files) == 0 {
+		return nil, errors.New("files must not be empty")
+	}
 
-var watcher *fsnotify.Watcher
+	return cfg, nil
+}
 
-func (event SaveEvent) Init(ctx context.Context) error {
+func New(name string, cfg interface{}) (event.Event, error) {
+	return &SaveEvent{
+		name: name,
+		cfg:  cfg.(Config),
+	}, nil
+}
+
+func (e *SaveEvent) Paths() []string {
+	return e.cfg.Files
+}
+
+func (e *SaveEvent) Init(ctx context.Context) error {
 	log.Info().
-		Strs("files", config.Cfg.Events.Save.Files).
+		Str("name", e.name).
+		Strs("files", e.cfg.Files).
+		Dur("debounce", e.cfg.Debounce).
+		Dur("max-wait", e.cfg.MaxWait).
 		Msg("Initializing Save")
 
 	var err error
-	watcher, err = fsnotify.NewWatcher()
+	e.w, err = watcher.New(chrono.RootPath, e.cfg.Files)
 	if err != nil {
 		return err
 	}
 
-	for _, file := range config.Cfg.Events.Save.Files {
-		err = watcher.Add(file)
-		if err != nil {
-			return fmt.Errorf("Couldn't add %v : %v", file, err.Error())
-		}
-	}
-
-	Save.ctx = ctx
+	e.ctx = ctx
 
 	return nil
 }
 
-func (event SaveEvent) Watch() error {
-	for {
-		select {
-		case <-Save.ctx.Done():
-			return nil
-
-		case e, ok := <-watcher.Events:
-			if !ok {
-				return fmt.Errorf("Couldn't read watcher event")
-			}
-
-			if e.Op&fsnotify.Write == fsnotify.Write {
-				scheduler.Notify(scheduler.SchedulerMessage{
-					Sender:  "Save",
-					Message: fmt.Sprintf("[Save] Updated %v %v", e.Name, time.Now().Format("15:04:05 02/01/2006")),
-					Paths:   config.Cfg.Events.Save.Files,
-				})
-			}
-
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return fmt.Errorf("Couldn't read watcher error")
-			}
-
-			return fmt.Errorf("Watcher error: %v", err.Error())
-		}
-
-	}
-
-	return nil
+// Watch coalesces the changes happening until no file is saved for the debounce period
+// (or until max-wait elapsed since the first change) into a single notification
+func (e *SaveEvent) Watch() error {
+	return e.w.Debounce(e.ctx, e.cfg.Debounce, e.cfg.MaxWait, func(paths []string) <-chan struct{} {
+		scheduler.Notify(scheduler.SchedulerMessage{
+			Sender:  "Save",
+			Message: fmt.Sprintf("[Save] Updated %v %v", watcher.ListFiles(chrono.RootPath, paths), time.Now().Format("15:04:05 02/01/2006")),
+			Paths:   paths,
+		})
+		return nil
+	})
 }
 
-func (event SaveEvent) Fini() error {
-	log.Info().Msg("Save stopped")
-	return watcher.Close()
+func (e *SaveEvent) Fini() error {
+	log.Info().Str("name", e.name).Msg("Save stopped")
+	return e.w.Close()
 }
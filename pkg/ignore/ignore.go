@@ -0,0 +1,210 @@
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const GitIgnoreFileName string = ".gitignore"
+const ChronoIgnoreFileName string = ".chronoignore"
+
+// Those are never worth watching nor committing
+var defaultRules = []string{".git/", ".chrono/"}
+
+type rule struct {
+	base    string
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher tells whether a path is ignored, following the .gitignore syntax
+type Matcher struct {
+	root  string
+	rules []rule
+}
+
+// New creates a matcher for the tree at root, with the rules of its .gitignore and .chronoignore files
+func New(root string) *Matcher {
+	m := &Matcher{root: root}
+
+	for _, r := range defaultRules {
+		m.AddRule("", r)
+	}
+
+	m.AddFile(filepath.Join(root, GitIgnoreFileName))
+	m.AddFile(filepath.Join(root, ChronoIgnoreFileName))
+
+	return m
+}
+
+// AddFile adds the rules of an ignore file, patterns are relative to the directory containing it
+func (m *Matcher) AddFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	base, err := m.rel(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m.AddRule(base, scanner.Text())
+	}
+
+	return scanner.Err()
+}
+
+// AddRule adds a single pattern, base is the slash separated directory it is relative to
+func (m *Matcher) AddRule(base string, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	r := rule{base: base}
+
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return
+	}
+
+	r.regexp = re
+	m.rules = append(m.rules, r)
+}
+
+// Ignored tells whether a path (absolute, or relative to the current directory) is ignored
+func (m *Matcher) Ignored(p string, isDir bool) bool {
+	rel, err := m.rel(p)
+	if err != nil || rel == "" {
+		return false
+	}
+
+	// A path inside an ignored directory is ignored too
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return m.match(rel, isDir)
+}
+
+func (m *Matcher) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+
+		p := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(rel, r.base+"/")
+		}
+
+		if r.regexp.MatchString(p) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
+
+// rel returns a slash separated path relative to the root, "" being the root itself
+func (m *Matcher) rel(p string) (string, error) {
+	root, err := filepath.Abs(m.root)
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+
+	rel = path.Clean(filepath.ToSlash(rel))
+	if rel == "." {
+		return "", nil
+	}
+
+	return rel, nil
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"chrono/pkg/config"
+
+	git "github.com/libgit2/git2go/v34"
+)
+
+type FileDiff struct {
+	Path    string
+	OldPath string
+
+	// A (added), D (deleted), M (modified), R (renamed) or T (type changed)
+	Status string
+
+	Insertions int
+	Deletions  int
+	Binary     bool
+}
+
+type DiffResult struct {
+	Files []FileDiff
+
+	// Unified diff of all the files
+	Patch string
+}
+
+var deltaStatus = map[git.Delta]string{
+	git.DeltaAdded:      "A",
+	git.DeltaUntracked:  "A",
+	git.DeltaDeleted:    "D",
+	git.DeltaModified:   "M",
+	git.DeltaRenamed:    "R",
+	git.DeltaCopied:     "C",
+	git.DeltaTypeChange: "T",
+}
+
+// Diff compares the trees of two commits, or the tree of from and the working tree if to is empty,
+// paths restricts the comparison to some files
+func (r *Repository) Diff(from string, to string, paths []string) (DiffResult, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return DiffResult{}, gitError("failed to get diff options", err)
+	}
+
+	if len(paths) > 0 {
+		opts.Pathspec, err = r.relPaths(paths)
+		if err != nil {
+			return DiffResult{}, err
+		}
+	}
+
+	oldTree, err := r.commitTree(from)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	defer oldTree.Free()
+
+	var diff *git.Diff
+	if to == "" {
+		// Untracked files would be part of the next snapshot
+		if config.Cfg.Git != nil && config.Cfg.Git.AutoAdd {
+			opts.Flags |= git.DiffIncludeUntracked | git.DiffRecurseUntracked | git.DiffShowUntrackedContent
+		}
+
+		diff, err = r.Git.DiffTreeToWorkdir(oldTree, &opts)
+	} else {
+		var newTree *git.Tree
+		newTree, err = r.commitTree(to)
+		if err != nil {
+			return DiffResult{}, err
+		}
+		defer newTree.Free()
+
+		diff, err = r.Git.DiffTreeToTree(oldTree, newTree, &opts)
+	}
+	if err != nil {
+		return DiffResult{}, gitError("failed to compute diff", err)
+	}
+	defer diff.Free()
+
+	return readDiff(diff)
+}
+
+func (r *Repository) diffTrees(oldTree *git.Tree, newTree *git.Tree) (DiffResult, error) {
+	diff, err := r.Git.DiffTreeToTree(oldTree, newTree, nil)
+	if err != nil {
+		return DiffResult{}, gitError("failed to compute diff", err)
+	}
+	defer diff.Free()
+
+	return readDiff(diff)
+}
+
+// readDiff detects renames and collects the changed files, their stats and the patch of a diff
+func readDiff(diff *git.Diff) (DiffResult, error) {
+	findOpts, err := git.DefaultDiffFindOptions()
+	if err != nil {
+		return DiffResult{}, gitError("failed to get diff find options", err)
+	}
+
+	err = diff.FindSimilar(&findOpts)
+	if err != nil {
+		return DiffResult{}, gitError("failed to detect renames", err)
+	}
+
+	result := DiffResult{}
+	err = diff.ForEach(func(delta git.DiffDelta, progress float64) (git.DiffForEachHunkCallback, error) {
+		result.Files = append(result.Files, FileDiff{
+			Path:    delta.NewFile.Path,
+			OldPath: delta.OldFile.Path,
+			Status:  deltaStatus[delta.Status],
+			Binary:  delta.Flags&git.DiffFlagBinary != 0,
+		})
+		i := len(result.Files) - 1
+
+		return func(hunk git.DiffHunk) (git.DiffForEachLineCallback, error) {
+			return func(line git.DiffLine) error {
+				switch line.Origin {
+				case git.DiffLineAddition:
+					result.Files[i].Insertions++
+				case git.DiffLineDeletion:
+					result.Files[i].Deletions++
+				}
+				return nil
+			}, nil
+		}, nil
+	}, git.DiffDetailLines)
+	if err != nil {
+		return DiffResult{}, gitError("failed to read diff", err)
+	}
+
+	patch, err := diff.ToBuf(git.DiffFormatPatch)
+	if err != nil {
+		return DiffResult{}, gitError("failed to format diff", err)
+	}
+	result.Patch = string(patch)
+
+	return result, nil
+}
+
+func (r *Repository) commitTree(hash string) (*git.Tree, error) {
+	oid, err := git.NewOid(hash)
+	if err != nil {
+		return nil, gitError("invalid commit id "+hash, err)
+	}
+
+	commit, err := r.Git.LookupCommit(oid)
+	if err != nil {
+		return nil, gitError("failed to lookup commit "+hash, err)
+	}
+	defer commit.Free()
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, gitError("failed to get commit tree", err)
+	}
+
+	return tree, nil
+}
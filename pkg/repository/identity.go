@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"chrono/pkg/config"
+	"time"
+
+	git "github.com/libgit2/git2go/v34"
+	"github.com/rs/zerolog/log"
+)
+
+// Used when neither the config nor git tell who commits
+const fallbackName = "Chrono"
+const fallbackEmail = "chrono@localhost"
+
+// signature is the identity commits are made with, the one of the git config (user.name and user.email)
+// unless overridden by the identity of the chrono config
+func (r *Repository) signature() *git.Signature {
+	sig := &git.Signature{
+		Name:  fallbackName,
+		Email: fallbackEmail,
+		When:  time.Now(),
+	}
+
+	def, err := r.Git.DefaultSignature()
+	if err == nil {
+		sig.Name = def.Name
+		sig.Email = def.Email
+	}
+
+	if config.Cfg.Git != nil && config.Cfg.Git.Identity != nil {
+		if config.Cfg.Git.Identity.Name != "" {
+			sig.Name = config.Cfg.Git.Identity.Name
+		}
+		if config.Cfg.Git.Identity.Email != "" {
+			sig.Email = config.Cfg.Git.Identity.Email
+		}
+	}
+
+	if err != nil && sig.Name == fallbackName {
+		log.Warn().Err(err).Msg("No git identity configured, committing as " + fallbackName)
+	}
+
+	return sig
+}
@@ -0,0 +1,508 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	git "github.com/libgit2/git2go/v34"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNoMergeInProgress = errors.New("no merge in progress")
+
+// MergeConflictError is returned when a merge stopped on conflicts, the merge is left in progress
+type MergeConflictError struct {
+	Files []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("%v in %v", ErrMergeConflict, strings.Join(e.Files, ", "))
+}
+
+func (e *MergeConflictError) Is(target error) bool {
+	return target == ErrMergeConflict
+}
+
+// MergePreview is what squash merging src into dst would change
+type MergePreview struct {
+	Diff      DiffResult
+	Conflicts []string
+}
+
+// PreviewMerge computes the changes squash merging src into dst would make, without touching anything
+func (r *Repository) PreviewMerge(dst string, src string) (MergePreview, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dstCommit, err := r.refCommit(dst)
+	if err != nil {
+		return MergePreview{}, err
+	}
+	defer dstCommit.Free()
+
+	srcCommit, err := r.refCommit(src)
+	if err != nil {
+		return MergePreview{}, err
+	}
+	defer srcCommit.Free()
+
+	mergeOpts, err := git.DefaultMergeOptions()
+	if err != nil {
+		return MergePreview{}, gitError("DefaultMergeOptions() failed", err)
+	}
+
+	index, err := r.Git.MergeCommits(dstCommit, srcCommit, &mergeOpts)
+	if err != nil {
+		return MergePreview{}, gitError("merge failed", err)
+	}
+	defer index.Free()
+
+	preview := MergePreview{}
+	if index.HasConflicts() {
+		conflicts, err := conflicts(index)
+		if err != nil {
+			return MergePreview{}, err
+		}
+
+		// The conflicting files are kept as they are in dst, what can be merged is still shown
+		for _, c := range conflicts {
+			path := conflictPath(c)
+			preview.Conflicts = append(preview.Conflicts, path)
+
+			err = index.RemoveConflict(path)
+			if err == nil && c.Our != nil {
+				err = index.Add(c.Our)
+			}
+			if err != nil {
+				return MergePreview{}, gitError("failed to set aside conflict "+path, err)
+			}
+		}
+	}
+
+	treeId, err := index.WriteTreeTo(r.Git)
+	if err != nil {
+		return MergePreview{}, gitError("failed to write tree", err)
+	}
+
+	merged, err := r.Git.LookupTree(treeId)
+	if err != nil {
+		return MergePreview{}, gitError("failed to lookup tree", err)
+	}
+	defer merged.Free()
+
+	dstTree, err := dstCommit.Tree()
+	if err != nil {
+		return MergePreview{}, gitError("failed to get commit tree", err)
+	}
+	defer dstTree.Free()
+
+	preview.Diff, err = r.diffTrees(dstTree, merged)
+	if err != nil {
+		return MergePreview{}, err
+	}
+
+	return preview, nil
+}
+
+// ContinueMerge commits a merge left in progress by SquashMerge once its conflicts are resolved
+func (r *Repository) ContinueMerge(msg string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Git.State() != git.RepositoryStateMerge {
+		return ErrNoMergeInProgress
+	}
+
+	index, err := r.Git.Index()
+	if err != nil {
+		return gitError("failed to retreive index", err)
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		files, err := conflictedFiles(index)
+		if err != nil {
+			return err
+		}
+
+		return &MergeConflictError{Files: files}
+	}
+
+	treeId, err := index.WriteTree()
+	if err != nil {
+		return gitError("failed to write tree", err)
+	}
+
+	t, err := r.Git.LookupTree(treeId)
+	if err != nil {
+		return gitError("failed to lookup tree", err)
+	}
+	defer t.Free()
+
+	return r.commitMerge(t, msg)
+}
+
+// AbortMerge cancels a merge left in progress by SquashMerge, and checks out original again
+func (r *Repository) AbortMerge(original string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Git.State() != git.RepositoryStateMerge {
+		return ErrNoMergeInProgress
+	}
+
+	r.rollbackMerge(original)
+	return nil
+}
+
+// commitMerge commits the squashed tree on top of HEAD, with HEAD as the only parent
+func (r *Repository) commitMerge(t *git.Tree, msg string) error {
+	head, err := r.Git.Head()
+	if err != nil {
+		return gitError("failed to get HEAD", err)
+	}
+	defer head.Free()
+
+	currentCommit, err := r.Git.LookupCommit(head.Target())
+	if err != nil {
+		return gitError("failed to get current commit", err)
+	}
+	defer currentCommit.Free()
+
+	sig := r.signature()
+	commitId, err := r.Git.CreateCommit("HEAD", sig, sig, msg, t, currentCommit)
+	if err != nil {
+		return gitError("failed to create commit", err)
+	}
+
+	log.Info().Str("id", commitId.String()).Msg("New git commit")
+
+	err = r.Git.CheckoutHead(&git.CheckoutOptions{
+		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Couldn't checkout HEAD after merge")
+	}
+
+	cleanupErr := r.Git.StateCleanup()
+	if cleanupErr != nil {
+		log.Warn().Err(cleanupErr).Msg("Couldn't cleanup merge state")
+	}
+
+	return nil
+}
+
+func (r *Repository) refCommit(name string) (*git.Commit, error) {
+	ref, err := r.lookupRef(name)
+	if err != nil {
+		return nil, err
+	}
+	defer ref.Free()
+
+	commit, err := r.Git.LookupCommit(ref.Target())
+	if err != nil {
+		return nil, gitError("failed to lookup commit", err)
+	}
+
+	return commit, nil
+}
+
+func conflicts(index *git.Index) ([]git.IndexConflict, error) {
+	it, err := index.ConflictIterator()
+	if err != nil {
+		return nil, gitError("failed to list conflicts", err)
+	}
+	defer it.Free()
+
+	conflicts := []git.IndexConflict{}
+	for {
+		c, err := it.Next()
+		if git.IsErrorCode(err, git.ErrorCodeIterOver) {
+			break
+		}
+		if err != nil {
+			return nil, gitError("failed to list conflicts", err)
+		}
+
+		conflicts = append(conflicts, c)
+	}
+
+	return conflicts, nil
+}
+
+func conflictPath(c git.IndexConflict) string {
+	switch {
+	case c.Our != nil:
+		return c.Our.Path
+	case c.Their != nil:
+		return c.Their.Path
+	case c.Ancestor != nil:
+		return c.Ancestor.Path
+	}
+
+	return ""
+}
+
+func conflictedFiles(index *git.Index) ([]string, error) {
+	conflicts, err := conflicts(index)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		files = append(files, conflictPath(c))
+	}
+
+	return files, nil
+}
+
+// CreateBranchAt creates a local branch pointing to a commit
+func (r *Repository) CreateBranchAt(name string, hash string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	oid, err := git.NewOid(hash)
+	if err != nil {
+		return gitError("invalid commit id "+hash, err)
+	}
+
+	commit, err := r.Git.LookupCommit(oid)
+	if err != nil {
+		return gitError("failed to lookup commit "+hash, err)
+	}
+	defer commit.Free()
+
+	b, err := r.Git.CreateBranch(name, commit, false)
+	if err != nil {
+		return gitError("failed to create branch", err)
+	}
+	defer b.Free()
+
+	return nil
+}
+
+// IsCheckedOut tells whether a local branch is checked out, here or in another worktree
+func (r *Repository) IsCheckedOut(name string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	current, err := r.branchName()
+	if err == nil && current == name {
+		return true, nil
+	}
+
+	return r.checkedOutElsewhere("refs/heads/" + name)
+}
+
+// MergeBase returns the id of the best common ancestor of two references
+func (r *Repository) MergeBase(a string, b string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ca, err := r.refCommit(a)
+	if err != nil {
+		return "", err
+	}
+	defer ca.Free()
+
+	cb, err := r.refCommit(b)
+	if err != nil {
+		return "", err
+	}
+	defer cb.Free()
+
+	oid, err := r.Git.MergeBase(ca.Id(), cb.Id())
+	if err != nil {
+		return "", gitError("failed to find the merge base of "+a+" and "+b, err)
+	}
+
+	return oid.String(), nil
+}
+
+// CommitsSince counts the commits reachable from ref but not from base
+func (r *Repository) CommitsSince(base string, ref string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tip, err := r.refCommit(ref)
+	if err != nil {
+		return 0, err
+	}
+	defer tip.Free()
+
+	oid, err := git.NewOid(base)
+	if err != nil {
+		return 0, gitError("invalid commit id "+base, err)
+	}
+
+	k, err := r.Git.Walk()
+	if err != nil {
+		return 0, gitError("Walk() failed", err)
+	}
+	defer k.Free()
+
+	err = k.Push(tip.Id())
+	if err != nil {
+		return 0, gitError("Push() failed", err)
+	}
+
+	err = k.Hide(oid)
+	if err != nil {
+		return 0, gitError("Hide() failed", err)
+	}
+
+	n := 0
+	err = k.Iterate(func(c *git.Commit) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		return 0, gitError("Iterate() failed", err)
+	}
+
+	return n, nil
+}
+
+// SquashCommit commits on top of the branch dst the changes made from base to src, applied with a 3-way merge,
+// without touching HEAD, the index nor the working tree, on conflicts nothing is changed
+func (r *Repository) SquashCommit(dst string, base string, src string, msg string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dstRef, err := r.Git.References.Lookup("refs/heads/" + dst)
+	if err != nil {
+		return "", gitError("failed to lookup branch "+dst, err)
+	}
+	defer dstRef.Free()
+
+	dstCommit, err := r.Git.LookupCommit(dstRef.Target())
+	if err != nil {
+		return "", gitError("failed to lookup commit", err)
+	}
+	defer dstCommit.Free()
+
+	srcCommit, err := r.refCommit(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcCommit.Free()
+
+	baseTree, err := r.commitTree(base)
+	if err != nil {
+		return "", err
+	}
+	defer baseTree.Free()
+
+	dstTree, err := dstCommit.Tree()
+	if err != nil {
+		return "", gitError("failed to get commit tree", err)
+	}
+	defer dstTree.Free()
+
+	srcTree, err := srcCommit.Tree()
+	if err != nil {
+		return "", gitError("failed to get commit tree", err)
+	}
+	defer srcTree.Free()
+
+	mergeOpts, err := git.DefaultMergeOptions()
+	if err != nil {
+		return "", gitError("DefaultMergeOptions() failed", err)
+	}
+
+	index, err := r.Git.MergeTrees(baseTree, dstTree, srcTree, &mergeOpts)
+	if err != nil {
+		return "", gitError("merge failed", err)
+	}
+	defer index.Free()
+
+	if index.HasConflicts() {
+		files, err := conflictedFiles(index)
+		if err != nil {
+			return "", err
+		}
+
+		return "", &MergeConflictError{Files: files}
+	}
+
+	treeId, err := index.WriteTreeTo(r.Git)
+	if err != nil {
+		return "", gitError("failed to write tree", err)
+	}
+
+	if treeId.Equal(dstTree.Id()) {
+		return "", ErrNothingToMerge
+	}
+
+	t, err := r.Git.LookupTree(treeId)
+	if err != nil {
+		return "", gitError("failed to lookup tree", err)
+	}
+	defer t.Free()
+
+	sig := r.signature()
+	commitId, err := r.Git.CreateCommit(dstRef.Name(), sig, sig, msg, t, dstCommit)
+	if err != nil {
+		return "", gitError("failed to create commit", err)
+	}
+
+	log.Info().Str("id", commitId.String()).Str("branch", dst).Msg("New git commit")
+	return commitId.String(), nil
+}
+
+// UpdateWorkingTree checks out HEAD again after a commit was made on its branch without touching the working
+// tree, files changed by the user are left as they are, if that fails the branch is moved back to its previous commit
+func (r *Repository) UpdateWorkingTree() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	head, err := r.Git.Head()
+	if err != nil {
+		return gitError("failed to get HEAD", err)
+	}
+	defer head.Free()
+
+	commit, err := r.Git.LookupCommit(head.Target())
+	if err != nil {
+		return gitError("failed to lookup commit", err)
+	}
+	defer commit.Free()
+
+	// The working tree still holds the previous commit, which is what it is compared to
+	previous := commit.Parent(0)
+	if previous == nil {
+		return errors.New("GIT Error, failed to lookup parent commit")
+	}
+	defer previous.Free()
+
+	baseline, err := previous.Tree()
+	if err != nil {
+		return gitError("failed to get commit tree", err)
+	}
+	defer baseline.Free()
+
+	err = r.Git.CheckoutHead(&git.CheckoutOptions{
+		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing,
+		Baseline: baseline,
+	})
+	if err == nil {
+		return nil
+	}
+
+	ref, refErr := head.Resolve()
+	if refErr == nil {
+		defer ref.Free()
+		var moved *git.Reference
+		moved, refErr = ref.SetTarget(previous.Id(), "chrono: undo merge, the working tree couldn't be updated")
+		if refErr == nil {
+			moved.Free()
+		}
+	}
+	if refErr != nil {
+		log.Error().Err(refErr).Msg("Couldn't move the branch back to its previous commit")
+	}
+
+	return gitError("failed to checkout HEAD", err)
+}
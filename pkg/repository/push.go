@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	git "github.com/libgit2/git2go/v34"
+)
+
+// LocalPath returns the path of a remote which is a local directory (a path or a file:// url), or false
+func LocalPath(remote string) (string, bool) {
+	if strings.HasPrefix(remote, "file://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", false
+		}
+		return u.Path, true
+	}
+
+	if filepath.IsAbs(remote) || strings.HasPrefix(remote, "./") || strings.HasPrefix(remote, "../") {
+		return remote, true
+	}
+
+	return "", false
+}
+
+// InitMirror creates a bare repository at path if there isn't one already
+func InitMirror(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	mirror, err := git.InitRepository(path, true)
+	if err != nil {
+		return gitError("failed to create mirror "+path, err)
+	}
+	mirror.Free()
+
+	return nil
+}
+
+// Push force pushes references to a remote, either the name of a configured remote or an url,
+// pushing the references with the same name on the remote
+func (r *Repository) Push(remote string, refs []string) error {
+	refspecs, path, err := r.pushRefspecs(refs)
+	if err != nil {
+		return err
+	}
+
+	// Pushing goes over the network, a handle of its own keeps the repository usable meanwhile
+	repo, err := git.OpenRepository(path)
+	if err != nil {
+		return gitError("failed to open GIT repository", err)
+	}
+	defer repo.Free()
+
+	rem, err := repo.Remotes.Lookup(remote)
+	if err != nil {
+		rem, err = repo.Remotes.CreateAnonymous(remote)
+	}
+	if err != nil {
+		return gitError("failed to open remote "+remote, err)
+	}
+	defer rem.Free()
+
+	var rejected []string
+	err = rem.Push(refspecs, &git.PushOptions{
+		RemoteCallbacks: git.RemoteCallbacks{
+			CredentialsCallback: func(url string, username string, allowed git.CredentialType) (*git.Credential, error) {
+				return git.NewCredentialSSHKeyFromAgent(username)
+			},
+			PushUpdateReferenceCallback: func(ref string, status string) error {
+				if status != "" {
+					rejected = append(rejected, ref+" ("+status+")")
+				}
+				return nil
+			},
+		},
+	})
+	if err != nil {
+		return gitError("failed to push to "+remote, err)
+	}
+
+	if len(rejected) > 0 {
+		return fmt.Errorf("GIT Error, the remote rejected %v", strings.Join(rejected, ", "))
+	}
+
+	return nil
+}
+
+// pushRefspecs resolves the references to push to the commits they point to, so that the push
+// doesn't see them change while it runs
+func (r *Repository) pushRefspecs(refs []string) ([]string, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	refspecs := make([]string, 0, len(refs))
+	for _, name := range refs {
+		ref, err := r.Git.References.Lookup(name)
+		if err != nil {
+			return nil, "", gitError("failed to find reference "+name, err)
+		}
+
+		resolved, err := ref.Resolve()
+		ref.Free()
+		if err != nil {
+			return nil, "", gitError("failed to resolve reference "+name, err)
+		}
+
+		refspecs = append(refspecs, "+"+resolved.Target().String()+":"+name)
+		resolved.Free()
+	}
+
+	return refspecs, r.Git.Path(), nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"strings"
+
+	git "github.com/libgit2/git2go/v34"
+)
+
+// SetRef makes a reference point to the given commit, creating it if needed
+func (r *Repository) SetRef(name string, hash string, msg string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	oid, err := git.NewOid(hash)
+	if err != nil {
+		return gitError("invalid commit id "+hash, err)
+	}
+
+	ref, err := r.Git.References.Create(name, oid, true, msg)
+	if err != nil {
+		return gitError("failed to create reference", err)
+	}
+	defer ref.Free()
+
+	return nil
+}
+
+// ListRefs returns the commit ids of the references starting with prefix, by reference name
+func (r *Repository) ListRefs(prefix string) (map[string]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	it, err := r.Git.NewReferenceIteratorGlob(prefix + "*")
+	if err != nil {
+		return nil, gitError("failed to list references", err)
+	}
+	defer it.Free()
+
+	refs := make(map[string]string)
+	for {
+		ref, err := it.Next()
+		if git.IsErrorCode(err, git.ErrorCodeIterOver) {
+			break
+		}
+		if err != nil {
+			return nil, gitError("failed to list references", err)
+		}
+
+		if strings.HasPrefix(ref.Name(), prefix) && ref.Target() != nil {
+			refs[ref.Name()] = ref.Target().String()
+		}
+		ref.Free()
+	}
+
+	return refs, nil
+}
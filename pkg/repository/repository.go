@@ -2,6 +2,11 @@ package repository
 
 import (
 	"chrono/pkg/config"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,61 +14,105 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+var ErrBranchChanged = errors.New("branch changed")
+var ErrMergeConflict = errors.New("merge conflicts")
+var ErrNothingToMerge = errors.New("nothing to merge")
+
+// Moving the checked out branch while git commits to it would make git's own commit fail
+var ErrIndexLocked = errors.New("git holds the index, the checked out branch can't be snapshotted until it is done")
+
+// BranchChangedError is returned when the checked out branch isn't the one of the session anymore,
+// Found is empty when HEAD is detached
+type BranchChangedError struct {
+	Expected string
+	Found    string
+}
+
+func (e *BranchChangedError) Error() string {
+	found := e.Found
+	if found == "" {
+		found = "a detached HEAD"
+	}
+
+	return fmt.Sprintf("%v: expected %v, found %v", ErrBranchChanged, e.Expected, found)
+}
+
+func (e *BranchChangedError) Is(target error) bool {
+	return target == ErrBranchChanged
+}
+
 type Repository struct {
 	Git           *git.Repository
 	sessionBranch string
+	shadowRef     string
+	blobs         map[string]cachedBlob
 	mutex         sync.Mutex
 }
 
 type CommitInfo struct {
-	Hash    string
-	Author  string
-	Message string
+	Hash    string    `json:"Hash"`
+	Parent  string    `json:"Parent,omitempty"`
+	Author  string    `json:"Author"`
+	Message string    `json:"Message"`
+	When    time.Time `json:"When"`
+
+	// Only filled by LoadStats
+	Files      []string `json:"Files"`
+	Insertions int      `json:"Insertions"`
+	Deletions  int      `json:"Deletions"`
+}
+
+func gitError(msg string, err error) error {
+	return fmt.Errorf("GIT Error, %v: %w", msg, err)
 }
 
-func Open(path string) *Repository {
-	r, err := git.OpenRepository(path)
+func Open(path string) (*Repository, error) {
+	r, err := git.OpenRepositoryExtended(path, 0, "")
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to open GIT repository")
+		return nil, gitError("failed to open GIT repository", err)
 	}
 
 	return &Repository{
 		Git: r,
-	}
+	}, nil
 }
 
-func (r *Repository) GetBranchName() string {
+func (r *Repository) GetBranchName() (string, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	return r.branchName()
+}
+
+func (r *Repository) branchName() (string, error) {
 	head, err := r.Git.Head()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get HEAD")
+		return "", gitError("failed to get HEAD", err)
 	}
 	defer head.Free()
 
 	branch := head.Branch()
 	currentBranchName, err := branch.Name()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get branch name")
+		return "", gitError("failed to get branch name", err)
 	}
 
-	return currentBranchName
+	return currentBranchName, nil
 }
 
-func (r *Repository) CreateBranch(name string) {
+func (r *Repository) CreateBranch(name string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	head, err := r.Git.Head()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get HEAD")
+		return gitError("failed to get HEAD", err)
 	}
 	defer head.Free()
 
 	commit, err := r.Git.LookupCommit(head.Target())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get current commit")
+		return gitError("failed to get current commit", err)
 	}
 	defer commit.Free()
 
@@ -71,360 +120,816 @@ func (r *Repository) CreateBranch(name string) {
 
 	b, err := r.Git.CreateBranch(name, commit, false)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to create branch")
+		return gitError("failed to create branch", err)
 	}
 	defer b.Free()
+
+	return nil
 }
 
-func (r *Repository) DeleteBranch(name string) {
+func (r *Repository) DeleteBranch(name string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	branch, err := r.Git.LookupBranch(name, git.BranchLocal)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup branch")
+		return gitError("failed to lookup branch", err)
 	}
 	defer branch.Free()
 
 	err = branch.Delete()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to delete branch")
+		return gitError("failed to delete branch", err)
 	}
+
+	return nil
 }
 
-func (r *Repository) CheckoutBranch(name string) {
+func (r *Repository) CheckoutBranch(name string) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	return r.checkoutBranch(name)
+}
+
+func (r *Repository) checkoutBranch(name string) error {
 	branch, err := r.Git.LookupBranch(name, git.BranchLocal)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup branch")
+		return gitError("failed to lookup branch", err)
 	}
 	defer branch.Free()
 
+	elsewhere, err := r.checkedOutElsewhere(branch.Reference.Name())
+	if err != nil {
+		return err
+	}
+
+	if elsewhere {
+		return fmt.Errorf("%w: %v", ErrBranchCheckedOut, name)
+	}
+
 	commit, err := r.Git.LookupCommit(branch.Target())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get last commit")
+		return gitError("failed to get last commit", err)
 	}
 	defer commit.Free()
 
 	tree, err := commit.Tree()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to retreive tree")
+		return gitError("failed to retreive tree", err)
 	}
 	defer tree.Free()
 
 	err = r.Git.CheckoutTree(tree, &git.CheckoutOptions{Strategy: git.CheckoutSafe})
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to checkout tree")
+		return gitError("failed to checkout tree", err)
 	}
 
 	err = r.Git.SetHead(branch.Reference.Name())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to set HEAD")
+		return gitError("failed to set HEAD", err)
 	}
 
 	r.sessionBranch = name
+	return nil
 }
 
-func (r *Repository) AssertBranchNotChanged() {
+// AssertBranchNotChanged returns a *BranchChangedError if the checked out branch isn't the one
+// of the session anymore, for shadow sessions it is the branch that was checked out when they started
+func (r *Repository) AssertBranchNotChanged() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	detached, err := r.Git.IsHeadDetached()
+	if err != nil {
+		return gitError("failed to get HEAD", err)
+	}
+
+	currentBranchName := ""
+	if !detached {
+		currentBranchName, err = r.branchName()
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.sessionBranch != currentBranchName {
+		return &BranchChangedError{Expected: r.sessionBranch, Found: currentBranchName}
+	}
+
+	return nil
+}
+
+// HeadId returns the id of the commit HEAD points to
+func (r *Repository) HeadId() (string, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	head, err := r.Git.Head()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get HEAD")
+		return "", gitError("failed to get HEAD", err)
 	}
 	defer head.Free()
 
-	branch := head.Branch()
-	currentBranchName, err := branch.Name()
+	return head.Target().String(), nil
+}
+
+// FollowBranch makes name the expected branch of the session, without checking it out,
+// commits go to it through HEAD again
+func (r *Repository) FollowBranch(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.sessionBranch = name
+	r.shadowRef = ""
+}
+
+// BranchExists tells whether a local branch exists
+func (r *Repository) BranchExists(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	branch, err := r.Git.LookupBranch(name, git.BranchLocal)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get branch name")
+		return false
 	}
+	branch.Free()
 
-	if r.sessionBranch != currentBranchName {
-		log.Fatal().Str("expected", r.sessionBranch).
-			Str("found", currentBranchName).
-			Msg("Branch changed ! Please make sure the branch doesn't get changed while chrono is running")
-	}
+	return true
 }
 
-func (r *Repository) Commit(paths []string, author string, message string) {
+// Commit stages the given paths (relative to the current directory, nil meaning the whole tree) and commits them,
+// when snapshot-all is enabled the whole tree is staged whatever the paths are,
+// the id of the new commit is returned, or "" if there was nothing to commit
+func (r *Repository) Commit(paths []string, author string, message string) (string, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	pathspec, err := r.pathspec(paths)
+	if err != nil {
+		return "", err
+	}
+
+	if r.shadowRef != "" {
+		return r.snapshot(r.shadowRef, pathspec, author, message)
+	}
+
+	if r.indexLocked() {
+		return "", ErrIndexLocked
+	}
+
 	head, err := r.Git.Head()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get HEAD")
+		return "", gitError("failed to get HEAD", err)
 	}
 	defer head.Free()
 
 	branch := head.Branch()
 	index, err := r.Git.Index()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to retreive index")
+		return "", gitError("failed to retreive index", err)
 	}
 	defer index.Free()
 
 	updatesExist := false
-	err = index.UpdateAll(paths, func(s1, s2 string) error {
+	err = index.UpdateAll(pathspec, func(s1, s2 string) error {
 		updatesExist = true
 		return nil
 	})
+	if err != nil {
+		return "", gitError("failed to update index", err)
+	}
 
 	if config.Cfg.Git != nil && config.Cfg.Git.AutoAdd {
-		log.Info().Msg("Auto-adding all files")
-		index.AddAll([]string{"*"}, git.IndexAddCheckPathspec, func(s1, s2 string) error {
+		log.Info().Strs("paths", pathspec).Msg("Auto-adding files")
+		err = index.AddAll(pathspec, git.IndexAddDefault, func(s1, s2 string) error {
 			updatesExist = true
 			return nil
 		})
+		if err != nil {
+			return "", gitError("failed to add files", err)
+		}
+	}
+
+	if !updatesExist {
+		log.Info().Msg("Didn't commit, There are no updates")
+		return "", nil
 	}
 
+	return r.commitIndex(index, branch, author, message)
+}
+
+// indexLocked tells whether git is writing the index, as it does while running pre-commit hooks
+func (r *Repository) indexLocked() bool {
+	_, err := os.Stat(filepath.Join(r.Git.Path(), "index.lock"))
+	return err == nil
+}
+
+// pathspec converts the paths of an event into a pathspec relative to the working tree
+func (r *Repository) pathspec(paths []string) ([]string, error) {
+	if len(paths) == 0 || (config.Cfg.Git != nil && config.Cfg.Git.SnapshotAll) {
+		return []string{"*"}, nil
+	}
+
+	rel, err := r.relPaths(paths)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to update index")
+		return nil, err
 	}
 
-	if !updatesExist {
-		log.Info().Msg("Didn't commit, There are no updates")
-		return
+	for i, p := range rel {
+		if p == "." {
+			rel[i] = "*"
+		}
 	}
 
-	err = index.Write()
+	return rel, nil
+}
+
+// commitIndex writes the index and commits its tree on top of the given branch, returning the new commit id
+func (r *Repository) commitIndex(index *git.Index, branch *git.Branch, author string, message string) (string, error) {
+	err := index.Write()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to write index")
+		return "", gitError("failed to write index", err)
 	}
 
 	oid, err := index.WriteTree()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to write tree")
+		return "", gitError("failed to write tree", err)
 	}
 	tree, err := r.Git.LookupTree(oid)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup tree")
+		return "", gitError("failed to lookup tree", err)
 	}
 	defer tree.Free()
 
 	lastCommit, err := r.Git.LookupCommit(branch.Target())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup commit")
+		return "", gitError("failed to lookup commit", err)
 	}
 	defer lastCommit.Free()
 
-	sig := &git.Signature{
-		Name:  author,
-		Email: "Chrono",
-		When:  time.Now(),
+	if lastCommit.TreeId().Equal(oid) {
+		log.Info().Msg("Didn't commit, There are no updates")
+		return "", nil
 	}
 
-	commitId, err := r.Git.CreateCommit("HEAD", sig, sig, message, tree, lastCommit)
+	sig := r.signature()
+
+	commitId, err := r.Git.CreateCommit("HEAD", sig, sig, AddTrailer(message, EventTrailer, author), tree, lastCommit)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to create commit")
+		return "", gitError("failed to create commit", err)
 	}
 
-	r.Git.CheckoutHead(&git.CheckoutOptions{
+	err = r.Git.CheckoutHead(&git.CheckoutOptions{
 		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing,
 	})
+	if err != nil {
+		return "", gitError("failed to checkout HEAD", err)
+	}
 
 	log.Info().Str("id", commitId.String()).Msg("New git commit")
+	return commitId.String(), nil
 }
 
-func (r *Repository) SquashMerge(dst string, src string, msg string) {
+// Restore brings the working tree (or only the given paths) back to the state of a commit, and commits the result
+func (r *Repository) Restore(hash string, paths []string, author string, message string) (string, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	// Step 1: Checkout to destination branch
-	branch, err := r.Git.LookupBranch(dst, git.BranchLocal)
+	oid, err := git.NewOid(hash)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup branch")
+		return "", gitError("invalid commit hash", err)
 	}
-	defer branch.Free()
 
-	commit, err := r.Git.LookupCommit(branch.Target())
+	commit, err := r.Git.LookupCommit(oid)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get last commit")
+		return "", gitError("failed to lookup commit", err)
 	}
 	defer commit.Free()
 
 	tree, err := commit.Tree()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to retreive tree")
+		return "", gitError("failed to retreive tree", err)
 	}
 	defer tree.Free()
 
-	err = r.Git.CheckoutTree(tree, &git.CheckoutOptions{Strategy: git.CheckoutSafe})
+	rel, err := r.relPaths(paths)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to checkout tree")
+		return "", err
 	}
 
-	err = r.Git.SetHead(branch.Reference.Name())
+	if r.shadowRef != "" {
+		return r.restoreShadow(tree, rel, author, message)
+	}
+
+	err = r.Git.CheckoutTree(tree, &git.CheckoutOptions{
+		Strategy: git.CheckoutForce,
+		Paths:    rel,
+	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to set HEAD")
+		return "", gitError("failed to checkout tree", err)
 	}
 
-	r.sessionBranch = dst
+	head, err := r.Git.Head()
+	if err != nil {
+		return "", gitError("failed to get HEAD", err)
+	}
+	defer head.Free()
 
-	// Step 2: Get both the destination and source branch for later use
-	srcBranch, err := r.Git.LookupBranch(src, git.BranchLocal)
+	index, err := r.Git.Index()
 	if err != nil {
-		log.Fatal().Err(err).Str("src", src).Msg("GIT Error, Failed to lookup source branch")
+		return "", gitError("failed to retreive index", err)
 	}
-	defer srcBranch.Free()
+	defer index.Free()
+
+	return r.commitIndex(index, head.Branch(), author, message)
+}
 
-	dstBranch, err := r.Git.LookupBranch(dst, git.BranchLocal)
+// restoreShadow writes a tree to the working tree without updating the index, then snapshots the result,
+// the last snapshot is used as the expected state of the working tree so that files created since are removed
+func (r *Repository) restoreShadow(tree *git.Tree, paths []string, author string, message string) (string, error) {
+	reference, err := r.Git.References.Lookup(r.shadowRef)
 	if err != nil {
-		log.Fatal().Err(err).Str("destination", src).Msg("GIT Error, Failed to lookup destination branch")
+		return "", gitError("failed to lookup reference", err)
 	}
-	defer dstBranch.Free()
+	defer reference.Free()
 
-	// Step 3: Do merge analysis
-	ac, err := r.Git.AnnotatedCommitFromRef(srcBranch.Reference)
+	last, err := r.Git.LookupCommit(reference.Target())
 	if err != nil {
-		log.Fatal().Err(err).Str("src", src).Msg("GIT Error, Failed get annotated commit")
+		return "", gitError("failed to lookup commit", err)
 	}
-	defer ac.Free()
+	defer last.Free()
 
-	head, err := r.Git.Head()
+	baseline, err := last.Tree()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get HEAD")
+		return "", gitError("failed to retreive tree", err)
 	}
-	defer head.Free()
+	defer baseline.Free()
+
+	err = r.Git.CheckoutTree(tree, &git.CheckoutOptions{
+		Strategy: git.CheckoutForce | git.CheckoutDontUpdateIndex,
+		Paths:    paths,
+		Baseline: baseline,
+	})
+	if err != nil {
+		return "", gitError("failed to checkout tree", err)
+	}
+
+	pathspec := paths
+	if len(pathspec) == 0 {
+		pathspec = []string{"*"}
+	}
+
+	return r.snapshot(r.shadowRef, pathspec, author, message)
+}
+
+// relPaths converts paths relative to the current directory into paths relative to the working tree
+func (r *Repository) relPaths(paths []string) ([]string, error) {
+	wd, err := filepath.Abs(r.Git.Workdir())
+	if err != nil {
+		return nil, fmt.Errorf("invalid working directory: %w", err)
+	}
+
+	rel := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %v: %w", p, err)
+		}
+
+		p, err = filepath.Rel(wd, abs)
+		if err != nil || strings.HasPrefix(p, "..") {
+			return nil, fmt.Errorf("path %v is outside of the repository", abs)
+		}
+
+		rel = append(rel, filepath.ToSlash(p))
+	}
+
+	return rel, nil
+}
+
+// SquashMerge squashes the commits of src into a single commit on top of dst, if dst didn't move since src
+// was forked the tree of src is committed as is, on conflicts the merge is left in progress
+// (see ContinueMerge and AbortMerge), if anything else fails midway, the merge state is cleaned up
+// and the branch that was checked out is checked out again
+func (r *Repository) SquashMerge(dst string, src string, msg string) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	original, err := r.branchName()
+	if err != nil {
+		return err
+	}
+
+	// Step 1: Checkout to destination branch
+	err = r.checkoutBranch(dst)
+	if err != nil {
+		return err
+	}
+
+	// Whether the merge wrote to the index and the working tree, which then need to be reset
+	merged := false
+	defer func() {
+		if err == nil || errors.Is(err, ErrMergeConflict) {
+			return
+		}
+
+		if merged {
+			r.rollbackMerge(original)
+			return
+		}
+
+		if original != dst {
+			if checkoutErr := r.checkoutBranch(original); checkoutErr != nil {
+				log.Error().Err(checkoutErr).Msg("Couldn't checkout original branch")
+			}
+		}
+	}()
+
+	// Step 2: Get the source reference for later use
+	srcRef, err := r.lookupRef(src)
+	if err != nil {
+		return err
+	}
+	defer srcRef.Free()
+
+	// Step 3: Do merge analysis
+	ac, err := r.Git.AnnotatedCommitFromRef(srcRef)
+	if err != nil {
+		return gitError("failed get annotated commit", err)
+	}
+	defer ac.Free()
 
 	mergeHeads := make([]*git.AnnotatedCommit, 1)
 	mergeHeads[0] = ac
 	analysis, _, err := r.Git.MergeAnalysis(mergeHeads)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Merge analysis failed")
+		return gitError("merge analysis failed", err)
 	}
 
 	if analysis&git.MergeAnalysisNone != 0 || analysis&git.MergeAnalysisUpToDate != 0 {
-		log.Fatal().Msg("GIT Error, Nothing to merge")
+		return ErrNothingToMerge
+	}
+
+	// Step 4: Merge, a fast-forward only needs the tree of src
+	if analysis&git.MergeAnalysisFastForward != 0 {
+		srcCommit, err := r.Git.LookupCommit(srcRef.Target())
+		if err != nil {
+			return gitError("failed to lookup commit", err)
+		}
+		defer srcCommit.Free()
+
+		t, err := srcCommit.Tree()
+		if err != nil {
+			return gitError("failed to get commit tree", err)
+		}
+		defer t.Free()
+
+		return r.commitMerge(t, msg)
 	}
 
 	if analysis&git.MergeAnalysisNormal == 0 {
-		log.Fatal().Msg("GIT Error, Git merge analysis reported a not normal merge")
+		return errors.New("GIT Error, merge analysis reported a not normal merge")
 	}
 
-	// Step 4: Merge
 	mergeOpts, err := git.DefaultMergeOptions()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, DefaultMergeOptions() failed")
+		return gitError("DefaultMergeOptions() failed", err)
 	}
 
 	mergeOpts.FileFavor = git.MergeFileFavorNormal
-	mergeOpts.TreeFlags = git.MergeTreeFailOnConflict
 
 	checkoutOpts := &git.CheckoutOptions{
-		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing | git.CheckoutUseTheirs,
+		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing | git.CheckoutAllowConflicts | git.CheckoutConflictStyleMerge,
 	}
 
+	merged = true
 	err = r.Git.Merge(mergeHeads, &mergeOpts, checkoutOpts)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT error, Merge failed")
+		return gitError("merge failed", err)
 	}
 
 	index, err := r.Git.Index()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to retreive index")
+		return gitError("failed to retreive index", err)
 	}
 	defer index.Free()
 
 	if index.HasConflicts() {
-		log.Fatal().Msg("GIT Error, Merge conflicts, please solve them and commit manually")
+		files, err := conflictedFiles(index)
+		if err != nil {
+			return err
+		}
+
+		return &MergeConflictError{Files: files}
 	}
 
 	// Step 5: Commit
-	commit, err = r.Git.LookupCommit(dstBranch.Target())
+	treeId, err := index.WriteTree()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup commit")
+		return gitError("failed to write tree", err)
 	}
-	defer commit.Free()
 
-	sig := &git.Signature{
-		Name:  "Chrono",
-		Email: "Chrono",
-		When:  time.Now(),
+	t, err := r.Git.LookupTree(treeId)
+	if err != nil {
+		return gitError("failed to lookup tree", err)
 	}
+	defer t.Free()
 
-	treeId, err := index.WriteTree()
+	return r.commitMerge(t, msg)
+}
+
+// rollbackMerge undoes a partial squash merge, the destination branch is reset to its
+// last commit and the branch that was checked out before the merge is checked out again
+func (r *Repository) rollbackMerge(original string) {
+	log.Warn().Str("branch", original).Msg("Merge failed, rolling back")
+
+	err := r.resetMerge()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Failed to write tree")
+		log.Error().Err(err).Msg("Rollback: couldn't reset working tree")
 	}
 
-	t, err := r.Git.LookupTree(treeId)
+	err = r.Git.StateCleanup()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Failed to lookup tree")
+		log.Error().Err(err).Msg("Rollback: couldn't cleanup merge state")
 	}
-	defer t.Free()
 
-	currentCommit, err := r.Git.LookupCommit(head.Target())
+	err = r.checkoutBranch(original)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT error, Failed to get current commit")
+		log.Error().Err(err).Msg("Rollback: couldn't checkout original branch")
 	}
-	defer currentCommit.Free()
+}
 
-	commitId, err := r.Git.CreateCommit("HEAD", sig, sig, msg, t, currentCommit)
+// indexTree writes the current index as a tree
+func (r *Repository) indexTree() (*git.Tree, error) {
+	index, err := r.Git.Index()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to create commit")
+		return nil, gitError("failed to retreive index", err)
 	}
+	defer index.Free()
 
-	r.Git.CheckoutHead(&git.CheckoutOptions{
-		Strategy: git.CheckoutSafe | git.CheckoutRecreateMissing,
+	oid, err := index.WriteTree()
+	if err != nil {
+		return nil, gitError("failed to write tree", err)
+	}
+
+	tree, err := r.Git.LookupTree(oid)
+	if err != nil {
+		return nil, gitError("failed to lookup tree", err)
+	}
+
+	return tree, nil
+}
+
+// resetMerge brings back what the merge changed to HEAD, other changes of the working tree are kept
+func (r *Repository) resetMerge() error {
+	baseline, err := r.indexTree()
+	if err == nil {
+		defer baseline.Free()
+
+		// Files that don't hold what the merge wrote anymore are left alone
+		err = r.Git.CheckoutHead(&git.CheckoutOptions{
+			Strategy: git.CheckoutSafe,
+			Baseline: baseline,
+		})
+		if err != nil {
+			return gitError("failed to checkout HEAD", err)
+		}
+		return nil
+	}
+
+	// A conflicted index can't be used as a baseline, since the merge refuses to touch modified files,
+	// the files it changed are reset and nothing else
+	paths, err := r.mergedPaths()
+	if err != nil {
+		return err
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	err = r.Git.CheckoutHead(&git.CheckoutOptions{
+		Strategy: git.CheckoutForce | git.CheckoutDisablePathspecMatch,
+		Paths:    paths,
 	})
+	if err != nil {
+		return gitError("failed to checkout HEAD", err)
+	}
 
-	log.Info().Str("id", commitId.String()).Msg("New git commit")
+	return nil
+}
 
-	// Step 6: Cleanup the state
-	err = r.Git.StateCleanup()
+// mergedPaths lists the files the index changes compared to HEAD, conflicted ones included
+func (r *Repository) mergedPaths() ([]string, error) {
+	head, err := r.Git.Head()
+	if err != nil {
+		return nil, gitError("failed to get HEAD", err)
+	}
+	defer head.Free()
+
+	tree, err := r.commitTree(head.Target().String())
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Free()
+
+	index, err := r.Git.Index()
+	if err != nil {
+		return nil, gitError("failed to retreive index", err)
+	}
+	defer index.Free()
+
+	diff, err := r.Git.DiffTreeToIndex(tree, index, nil)
+	if err != nil {
+		return nil, gitError("failed to diff index", err)
+	}
+	defer diff.Free()
+
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return nil, gitError("failed to count changes", err)
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(p string) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		delta, err := diff.Delta(i)
+		if err != nil {
+			return nil, gitError("failed to get change", err)
+		}
+		add(delta.OldFile.Path)
+		add(delta.NewFile.Path)
+	}
+
+	conflicts, err := conflictedFiles(index)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Failed to cleanup state")
+		return nil, err
+	}
+	for _, p := range conflicts {
+		add(p)
 	}
+
+	return paths, nil
 }
 
-func (r *Repository) GetCommits(branchName string) []CommitInfo {
+// GetCommits lists the Chrono commits reachable from a branch or a reference, stopping at base
+// and leaving out the commits reachable from the hidden references
+func (r *Repository) GetCommits(refName string, base string, hide []string) ([]CommitInfo, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	branch, err := r.Git.LookupBranch(branchName, git.BranchLocal)
+	ref, err := r.lookupRef(refName)
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to lookup branch")
+		return nil, err
 	}
-	defer branch.Free()
+	defer ref.Free()
 
-	commit, err := r.Git.LookupCommit(branch.Target())
+	commit, err := r.Git.LookupCommit(ref.Target())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, failed to get last commit")
+		return nil, gitError("failed to get last commit", err)
 	}
 	defer commit.Free()
 
 	k, err := commit.Owner().Walk()
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Walk() failed")
+		return nil, gitError("Walk() failed", err)
 	}
+	defer k.Free()
 
 	err = k.Push(commit.Id())
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Push() failed")
+		return nil, gitError("Push() failed", err)
+	}
+
+	if base != "" {
+		oid, err := git.NewOid(base)
+		if err != nil {
+			return nil, gitError("invalid base commit "+base, err)
+		}
+
+		err = k.Hide(oid)
+		if err != nil {
+			return nil, gitError("Hide() failed", err)
+		}
+	}
+
+	for _, name := range hide {
+		h, err := r.Git.References.Lookup(name)
+		if err != nil {
+			// A deleted branch has nothing to hide
+			continue
+		}
+
+		err = k.Hide(h.Target())
+		h.Free()
+		if err != nil {
+			return nil, gitError("Hide() failed", err)
+		}
 	}
 
 	commits := []CommitInfo{}
 	err = k.Iterate(func(c *git.Commit) bool {
-		if c.Author().Email != "Chrono" {
+		event, ok := chronoEvent(c)
+		if !ok {
 			return true
 		}
 
-		commits = append(commits, CommitInfo{
+		info := CommitInfo{
 			Hash:    c.Id().String(),
-			Author:  c.Author().Name,
+			Author:  event,
 			Message: c.Message(),
-		})
-		//log.Debug().Str("msg", c.Message()).Str("hash", c.Id().String()).Msg("Debug")
+			When:    c.Committer().When,
+		}
+
+		if c.ParentCount() > 0 {
+			info.Parent = c.ParentId(0).String()
+		}
+
+		commits = append(commits, info)
 		return true
 	})
 
 	if err != nil {
-		log.Fatal().Err(err).Msg("GIT Error, Iterate() failed")
+		return nil, gitError("Iterate() failed", err)
+	}
+
+	return commits, nil
+}
+
+// LoadStats fills the changed files and the number of changed lines of commits, compared to their parent
+func (r *Repository) LoadStats(commits []CommitInfo) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i := range commits {
+		c := &commits[i]
+
+		tree, err := r.commitTree(c.Hash)
+		if err != nil {
+			return err
+		}
+
+		var parent *git.Tree
+		if c.Parent != "" {
+			parent, err = r.commitTree(c.Parent)
+			if err != nil {
+				tree.Free()
+				return err
+			}
+		}
+
+		err = r.diffStats(parent, tree, c)
+
+		tree.Free()
+		if parent != nil {
+			parent.Free()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) diffStats(oldTree *git.Tree, newTree *git.Tree, c *CommitInfo) error {
+	diff, err := r.Git.DiffTreeToTree(oldTree, newTree, nil)
+	if err != nil {
+		return gitError("failed to compute diff", err)
 	}
+	defer diff.Free()
+
+	n, err := diff.NumDeltas()
+	if err != nil {
+		return gitError("failed to read diff", err)
+	}
+
+	c.Files = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		delta, err := diff.Delta(i)
+		if err != nil {
+			return gitError("failed to read diff", err)
+		}
+		c.Files = append(c.Files, delta.NewFile.Path)
+	}
+
+	stats, err := diff.Stats()
+	if err != nil {
+		return gitError("failed to get diff stats", err)
+	}
+	defer stats.Free()
+
+	c.Insertions = stats.Insertions()
+	c.Deletions = stats.Deletions()
 
-	return commits
+	return nil
 }
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"errors"
+
+	git "github.com/libgit2/git2go/v34"
+	"github.com/rs/zerolog/log"
+)
+
+// RewriteChain rewrites the first-parent history of a reference down to base, leaving out the Chrono commits
+// for which keep returns false, the tip and the commits not made by Chrono are always kept,
+// the new ids of the kept commits are returned by their old id
+func (r *Repository) RewriteChain(refName string, base string, keep func(hash string) bool) (map[string]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ref, err := r.Git.References.Lookup(refName)
+	if err != nil {
+		return nil, gitError("failed to lookup reference "+refName, err)
+	}
+	defer ref.Free()
+
+	// Newest first, down to the first commit after base
+	chain := []*git.Commit{}
+	defer func() {
+		for _, c := range chain {
+			c.Free()
+		}
+	}()
+
+	commit, err := r.Git.LookupCommit(ref.Target())
+	if err != nil {
+		return nil, gitError("failed to lookup commit", err)
+	}
+
+	for commit != nil {
+		_, chrono := chronoEvent(commit)
+		if commit.Id().String() == base || (base == "" && !chrono) {
+			commit.Free()
+			break
+		}
+
+		chain = append(chain, commit)
+		commit = commit.Parent(0)
+	}
+
+	if len(chain) == 0 {
+		return map[string]string{}, nil
+	}
+
+	// The parent of the oldest commit of the chain stays the same
+	var parent *git.Commit
+	if oldest := chain[len(chain)-1]; oldest.ParentCount() > 0 {
+		parent = oldest.Parent(0)
+		if parent == nil {
+			return nil, errors.New("GIT Error, failed to lookup parent commit")
+		}
+		defer parent.Free()
+	}
+
+	ids := make(map[string]string)
+	rewriting := false
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		c := chain[i]
+		_, chrono := chronoEvent(c)
+
+		if i > 0 && chrono && !keep(c.Id().String()) {
+			rewriting = true
+			continue
+		}
+
+		if !rewriting {
+			ids[c.Id().String()] = c.Id().String()
+			parent = c
+			continue
+		}
+
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, gitError("failed to get commit tree", err)
+		}
+
+		parents := []*git.Commit{}
+		if parent != nil {
+			parents = append(parents, parent)
+		}
+
+		oid, err := r.Git.CreateCommit("", c.Author(), c.Committer(), c.Message(), tree, parents...)
+		tree.Free()
+		if err != nil {
+			return nil, gitError("failed to create commit", err)
+		}
+
+		parent, err = r.Git.LookupCommit(oid)
+		if err != nil {
+			return nil, gitError("failed to lookup commit", err)
+		}
+		defer parent.Free()
+
+		ids[c.Id().String()] = oid.String()
+	}
+
+	tip := chain[0].Id().String()
+	if ids[tip] != tip {
+		newRef, err := ref.SetTarget(parent.Id(), "chrono: compacted")
+		if err != nil {
+			return nil, gitError("failed to update reference", err)
+		}
+		newRef.Free()
+
+		log.Info().Str("ref", refName).Str("id", ids[tip]).Msg("Rewrote history")
+	}
+
+	return ids, nil
+}
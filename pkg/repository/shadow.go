@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"chrono/pkg/config"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/libgit2/git2go/v34"
+	"github.com/rs/zerolog/log"
+)
+
+// cachedBlob avoids hashing again files that didn't change since the last snapshot
+type cachedBlob struct {
+	size    int64
+	modTime time.Time
+	mode    git.Filemode
+	id      *git.Oid
+}
+
+// UseShadowRef makes Commit and Restore write snapshots directly to ref, using a private in-memory index,
+// so that HEAD, the index and the checked out branch of the user are never touched
+func (r *Repository) UseShadowRef(ref string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	detached, err := r.Git.IsHeadDetached()
+	if err != nil {
+		return gitError("failed to get HEAD", err)
+	}
+
+	r.sessionBranch = ""
+	if !detached {
+		r.sessionBranch, err = r.branchName()
+		if err != nil {
+			return err
+		}
+	}
+
+	r.shadowRef = ref
+	r.blobs = make(map[string]cachedBlob)
+	return nil
+}
+
+// RefExists tells whether a reference exists
+func (r *Repository) RefExists(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ref, err := r.Git.References.Lookup(name)
+	if err != nil {
+		return false
+	}
+	ref.Free()
+
+	return true
+}
+
+// CreateRef creates a reference pointing to the current HEAD commit
+func (r *Repository) CreateRef(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	head, err := r.Git.Head()
+	if err != nil {
+		return gitError("failed to get HEAD", err)
+	}
+	defer head.Free()
+
+	ref, err := r.Git.References.Create(name, head.Target(), false, "chrono: created session")
+	if err != nil {
+		return gitError("failed to create reference", err)
+	}
+	defer ref.Free()
+
+	return nil
+}
+
+func (r *Repository) DeleteRef(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ref, err := r.Git.References.Lookup(name)
+	if err != nil {
+		return gitError("failed to lookup reference", err)
+	}
+	defer ref.Free()
+
+	err = ref.Delete()
+	if err != nil {
+		return gitError("failed to delete reference", err)
+	}
+
+	return nil
+}
+
+// lookupRef finds a reference either by its full name or by a short branch name
+func (r *Repository) lookupRef(name string) (*git.Reference, error) {
+	ref, err := r.Git.References.Dwim(name)
+	if err != nil {
+		return nil, gitError("failed to lookup reference "+name, err)
+	}
+
+	return ref, nil
+}
+
+// snapshot commits the state of the working tree for the given pathspec on top of ref,
+// tracked files are the ones of the last snapshot, untracked ones are only added with auto-add
+func (r *Repository) snapshot(ref string, pathspec []string, author string, message string) (string, error) {
+	reference, err := r.Git.References.Lookup(ref)
+	if err != nil {
+		return "", gitError("failed to lookup reference", err)
+	}
+	defer reference.Free()
+
+	parent, err := r.Git.LookupCommit(reference.Target())
+	if err != nil {
+		return "", gitError("failed to lookup commit", err)
+	}
+	defer parent.Free()
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return "", gitError("failed to retreive tree", err)
+	}
+	defer parentTree.Free()
+
+	index, err := git.NewIndex()
+	if err != nil {
+		return "", gitError("failed to create index", err)
+	}
+	defer index.Free()
+
+	err = index.ReadTree(parentTree)
+	if err != nil {
+		return "", gitError("failed to read tree", err)
+	}
+
+	tracked := make(map[string]bool)
+	for i := uint(0); i < index.EntryCount(); i++ {
+		e, err := index.EntryByIndex(i)
+		if err != nil {
+			return "", gitError("failed to read index entry", err)
+		}
+
+		if e.Mode != git.FilemodeCommit {
+			tracked[e.Path] = true
+		}
+	}
+
+	for p := range tracked {
+		if matchPathspec(pathspec, p) {
+			err = r.stageFile(index, p)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if config.Cfg.Git != nil && config.Cfg.Git.AutoAdd {
+		err = r.stageUntracked(index, pathspec, tracked)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	treeId, err := index.WriteTreeTo(r.Git)
+	if err != nil {
+		return "", gitError("failed to write tree", err)
+	}
+
+	if parent.TreeId().Equal(treeId) {
+		log.Info().Msg("Didn't commit, There are no updates")
+		return "", nil
+	}
+
+	tree, err := r.Git.LookupTree(treeId)
+	if err != nil {
+		return "", gitError("failed to lookup tree", err)
+	}
+	defer tree.Free()
+
+	sig := r.signature()
+
+	commitId, err := r.Git.CreateCommit(ref, sig, sig, AddTrailer(message, EventTrailer, author), tree, parent)
+	if err != nil {
+		return "", gitError("failed to create commit", err)
+	}
+
+	log.Info().Str("id", commitId.String()).Str("ref", ref).Msg("New git commit")
+	return commitId.String(), nil
+}
+
+// stageUntracked adds the files of the pathspec that are neither tracked nor ignored
+func (r *Repository) stageUntracked(index *git.Index, pathspec []string, tracked map[string]bool) error {
+	wd := r.Git.Workdir()
+
+	for _, spec := range pathspec {
+		root := wd
+		if spec != "*" {
+			root = filepath.Join(wd, filepath.FromSlash(spec))
+		}
+
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p == root && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+
+			rel, err := filepath.Rel(wd, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			if d.Name() == ".git" {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if d.IsDir() {
+				if rel != "." {
+					ignored, err := r.Git.IsPathIgnored(rel + "/")
+					if err == nil && ignored {
+						return filepath.SkipDir
+					}
+				}
+				return nil
+			}
+
+			if tracked[rel] {
+				return nil
+			}
+
+			ignored, err := r.Git.IsPathIgnored(rel)
+			if err != nil || ignored {
+				return nil
+			}
+
+			return r.stageFile(index, rel)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stageFile writes the current content of a file of the working tree to the index, or removes it if it was deleted
+func (r *Repository) stageFile(index *git.Index, rel string) error {
+	full := filepath.Join(r.Git.Workdir(), filepath.FromSlash(rel))
+
+	info, err := os.Lstat(full)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		delete(r.blobs, rel)
+		err = index.RemoveByPath(rel)
+		if err != nil && !git.IsErrorCode(err, git.ErrorCodeNotFound) {
+			return gitError("failed to remove "+rel+" from index", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	mode := git.FilemodeBlob
+	if info.Mode()&fs.ModeSymlink != 0 {
+		mode = git.FilemodeLink
+	} else if info.Mode()&0111 != 0 {
+		mode = git.FilemodeBlobExecutable
+	}
+
+	cached, ok := r.blobs[rel]
+	if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) || cached.mode != mode {
+		var data []byte
+		if mode == git.FilemodeLink {
+			target, err := os.Readlink(full)
+			if err != nil {
+				return err
+			}
+			data = []byte(target)
+		} else {
+			data, err = os.ReadFile(full)
+			if err != nil {
+				return err
+			}
+		}
+
+		id, err := r.Git.CreateBlobFromBuffer(data)
+		if err != nil {
+			return gitError("failed to create blob for "+rel, err)
+		}
+
+		cached = cachedBlob{size: info.Size(), modTime: info.ModTime(), mode: mode, id: id}
+		r.blobs[rel] = cached
+	}
+
+	err = index.Add(&git.IndexEntry{
+		Path: rel,
+		Mode: mode,
+		Id:   cached.id,
+		Size: uint32(info.Size()),
+		Mtime: git.IndexTime{
+			Seconds:     int32(info.ModTime().Unix()),
+			Nanoseconds: uint32(info.ModTime().Nanosecond()),
+		},
+	})
+	if err != nil {
+		return gitError("failed to add "+rel+" to index", err)
+	}
+
+	return nil
+}
+
+func matchPathspec(pathspec []string, p string) bool {
+	for _, spec := range pathspec {
+		if spec == "*" || p == spec || strings.HasPrefix(p, spec+"/") {
+			return true
+		}
+	}
+
+	return false
+}
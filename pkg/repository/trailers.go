@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"strings"
+
+	git "github.com/libgit2/git2go/v34"
+)
+
+// EventTrailer marks the commits made by Chrono, its value is the event which made the commit
+const EventTrailer = "Chrono-Event"
+
+// Commits made before trailers existed are recognized by this fake email
+const legacyEmail = "Chrono"
+
+// trailerLines returns the lines of the last paragraph of a message if it only contains trailers
+func trailerLines(message string) []string {
+	message = strings.TrimRight(message, "\n")
+
+	i := strings.LastIndex(message, "\n\n")
+	if i < 0 {
+		return nil
+	}
+
+	lines := strings.Split(message[i+2:], "\n")
+	for _, line := range lines {
+		key, _, ok := strings.Cut(line, ":")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil
+		}
+	}
+
+	return lines
+}
+
+// Trailer returns the value of a trailer of a commit message, the last one if there are several
+func Trailer(message string, key string) string {
+	lines := trailerLines(message)
+
+	for i := len(lines) - 1; i >= 0; i-- {
+		k, value, _ := strings.Cut(lines[i], ":")
+		if k == key {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
+
+// AddTrailer appends a trailer to a commit message, in its trailers paragraph if it has one
+func AddTrailer(message string, key string, value string) string {
+	trailer := key + ": " + value
+
+	if trailerLines(message) != nil {
+		return strings.TrimRight(message, "\n") + "\n" + trailer
+	}
+
+	return strings.TrimRight(message, "\n") + "\n\n" + trailer
+}
+
+// chronoEvent returns the event which made a commit, or false if it wasn't made by Chrono
+func chronoEvent(c *git.Commit) (string, bool) {
+	if event := Trailer(c.Message(), EventTrailer); event != "" {
+		return event, true
+	}
+
+	if c.Author().Email == legacyEmail {
+		return c.Author().Name, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	git "github.com/libgit2/git2go/v34"
+)
+
+var ErrBranchCheckedOut = errors.New("branch is checked out in another worktree")
+
+// Discover finds the working tree containing path, which may be a subdirectory
+// of the main checkout or of a linked worktree
+func Discover(path string) (string, error) {
+	r, err := git.OpenRepositoryExtended(path, 0, "")
+	if err != nil {
+		return "", gitError("failed to open GIT repository", err)
+	}
+	defer r.Free()
+
+	if r.IsBare() {
+		return "", fmt.Errorf("%v is a bare repository", path)
+	}
+
+	return filepath.Clean(r.Workdir()), nil
+}
+
+// IsWorktree tells whether the repository is a linked worktree rather than the main checkout
+func (r *Repository) IsWorktree() bool {
+	_, err := os.Stat(filepath.Join(r.Git.Path(), "commondir"))
+	return err == nil
+}
+
+// commonDir is the git directory shared by the main checkout and all of its linked worktrees
+func (r *Repository) commonDir() string {
+	gitDir := filepath.Clean(r.Git.Path())
+
+	bytes, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+
+	dir := strings.TrimSpace(string(bytes))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+
+	return filepath.Clean(dir)
+}
+
+// checkedOutElsewhere tells whether a branch is the HEAD of another worktree of the repository,
+// libgit2 refuses to set HEAD to such a branch, so it must be checked before touching the working tree
+func (r *Repository) checkedOutElsewhere(refName string) (bool, error) {
+	common := r.commonDir()
+	own := filepath.Clean(r.Git.Path())
+
+	gitDirs := []string{common}
+	entries, err := os.ReadDir(filepath.Join(common, "worktrees"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			gitDirs = append(gitDirs, filepath.Join(common, "worktrees", e.Name()))
+		}
+	}
+
+	for _, dir := range gitDirs {
+		if dir == own {
+			continue
+		}
+
+		bytes, err := os.ReadFile(filepath.Join(dir, "HEAD"))
+		if err != nil {
+			continue
+		}
+
+		if strings.TrimSpace(strings.TrimPrefix(string(bytes), "ref:")) == refName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// HooksDir is the directory git runs hooks from, core.hooksPath if set
+func (r *Repository) HooksDir() string {
+	cfg, err := r.Git.Config()
+	if err == nil {
+		defer cfg.Free()
+
+		dir, err := cfg.LookupString("core.hooksPath")
+		if err == nil && dir != "" {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(r.Git.Workdir(), dir)
+			}
+			return filepath.Clean(dir)
+		}
+	}
+
+	return filepath.Join(r.commonDir(), "hooks")
+}
@@ -4,6 +4,7 @@ import (
 	"chrono/pkg/event/event"
 	"chrono/pkg/repository"
 	"context"
+	"errors"
 	"sync"
 
 	"github.com/rs/zerolog/log"
@@ -12,12 +13,42 @@ import (
 type SchedulerMessage struct {
 	Sender  string
 	Message string
-	Paths   []string
+
+	// Paths that triggered the event, only those get committed
+	Paths []string
+
+	// Optional label of the snapshot
+	Label string
+
+	// When not nil, receives the outcome of the commit once it is done
+	Done chan error
+}
+
+var ErrStopped = errors.New("scheduler stopped")
+
+// Guard is called before each commit and tells whether it should happen, an error stops the scheduler
+type Guard func() (bool, error)
+
+// CommitHook is called after each commit with the id of the new commit
+type CommitHook func(msg SchedulerMessage, id string)
+
+// Formatter turns a message into the commit message
+type Formatter func(msg SchedulerMessage) (string, error)
+
+// A running event, which has its own context so that it can be stopped alone
+type running struct {
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 var scheduler struct {
 	repository *repository.Repository
+	guard      Guard
+	onCommit   CommitHook
+	format     Formatter
 	channel    chan SchedulerMessage
+	calls      chan func()
+	events     map[event.Event]running
 	eventsWG   sync.WaitGroup
 	ctx        context.Context
 	mutex      sync.Mutex
@@ -25,54 +56,200 @@ var scheduler struct {
 
 func Init(ctx context.Context) {
 	scheduler.channel = make(chan SchedulerMessage)
+	scheduler.calls = make(chan func())
+	scheduler.events = make(map[event.Event]running)
 	scheduler.ctx = ctx
 	log.Info().Msg("Scheduler: Starting..")
 }
 
+// Fini waits for the events to stop, the channel is left open since control requests may
+// still notify, senders give up once the context is done
 func Fini() {
 	log.Info().Msg("Scheduler: Stopping..")
 	scheduler.eventsWG.Wait()
-	close(scheduler.channel)
 }
 
-func AddEvent(event event.Event) {
-	err := event.Init(scheduler.ctx)
+func AddEvent(event event.Event) error {
+	ctx, cancel := context.WithCancel(scheduler.ctx)
+
+	err := event.Init(ctx)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error")
+		cancel()
+		return err
 	}
 
+	r := running{cancel: cancel, done: make(chan struct{})}
+
+	scheduler.mutex.Lock()
+	scheduler.events[event] = r
+	scheduler.mutex.Unlock()
+
 	scheduler.eventsWG.Add(1)
 
 	go func() {
 		defer scheduler.eventsWG.Done()
-		event.Watch()
-		event.Fini()
+		defer close(r.done)
+		defer cancel()
+
+		err := event.Watch()
+		if err != nil {
+			log.Error().Err(err).Msg("Event stopped with an error")
+		}
+
+		err = event.Fini()
+		if err != nil {
+			log.Error().Err(err).Msg("Couldn't stop event")
+		}
 	}()
+
+	return nil
+}
+
+// RemoveEvent stops an event and waits until it is done, the other events keep running
+func RemoveEvent(event event.Event) {
+	scheduler.mutex.Lock()
+	r, ok := scheduler.events[event]
+	delete(scheduler.events, event)
+	scheduler.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	r.cancel()
+	<-r.done
 }
 
 func SetRepository(r *repository.Repository) {
 	scheduler.repository = r
 }
 
+func SetCommitHook(hook CommitHook) {
+	scheduler.onCommit = hook
+}
+
+// SetFormatter sets how commit messages are made, the message of the event is used as is by default
+func SetFormatter(format Formatter) {
+	scheduler.format = format
+}
+
+// SetGuard replaces the default guard, which stops the scheduler as soon as the branch changes
+func SetGuard(guard Guard) {
+	scheduler.guard = guard
+}
+
 func Notify(msg SchedulerMessage) {
-	scheduler.channel <- msg
+	select {
+	case <-scheduler.ctx.Done():
+	case scheduler.channel <- msg:
+	}
 }
 
-func Run() {
+// NotifyWait notifies the scheduler and waits until the commit is done
+func NotifyWait(msg SchedulerMessage) error {
+	msg.Done = make(chan error, 1)
+
+	select {
+	case <-scheduler.ctx.Done():
+		return ErrStopped
+	case scheduler.channel <- msg:
+	}
+
+	select {
+	case <-scheduler.ctx.Done():
+		return ErrStopped
+	case err := <-msg.Done:
+		return err
+	}
+}
+
+// Do runs f between two commits, it is meant to change what commits depend on while the scheduler runs
+func Do(f func()) error {
+	finished := make(chan struct{})
+
+	select {
+	case <-scheduler.ctx.Done():
+		return ErrStopped
+	case scheduler.calls <- func() { f(); close(finished) }:
+	}
+
+	<-finished
+	return nil
+}
+
+func done(msg SchedulerMessage, err error) {
+	if msg.Done != nil {
+		msg.Done <- err
+	}
+}
+
+func Run() error {
 	if scheduler.repository == nil {
-		log.Fatal().Msg("Can not start scheduler with a nil repository")
+		return errors.New("can not start scheduler with a nil repository")
+	}
+
+	if scheduler.guard == nil {
+		scheduler.guard = func() (bool, error) {
+			err := scheduler.repository.AssertBranchNotChanged()
+			return err == nil, err
+		}
 	}
 
 	log.Info().Msg("Scheduler: Running")
 	for {
 		select {
 		case <-scheduler.ctx.Done():
-			return
+			return nil
+		case f := <-scheduler.calls:
+			f()
 		case msg := <-scheduler.channel:
 			log.Info().Str("event", msg.Sender).Str("msg", msg.Message).Msg("Event")
 
-			scheduler.repository.AssertBranchNotChanged()
-			scheduler.repository.Commit(msg.Paths, msg.Sender, msg.Message)
+			ok, err := scheduler.guard()
+			if err != nil && msg.Done != nil {
+				// A git hook waiting for the snapshot mustn't stop the session, the next event does
+				log.Error().Err(err).Str("event", msg.Sender).Msg("Commit refused")
+				done(msg, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				log.Info().Str("event", msg.Sender).Msg("Skipped commit")
+				done(msg, nil)
+				continue
+			}
+
+			message := msg.Message
+			if scheduler.format != nil {
+				message, err = scheduler.format(msg)
+			}
+
+			id := ""
+			if err == nil {
+				id, err = scheduler.repository.Commit(msg.Paths, msg.Sender, message)
+			}
+			if err != nil && msg.Done != nil {
+				// Whoever waits for the commit gets the error, the session goes on
+				log.Error().Err(err).Str("event", msg.Sender).Msg("Commit failed")
+				done(msg, err)
+				continue
+			}
+			if errors.Is(err, repository.ErrIndexLocked) {
+				log.Warn().Err(err).Str("event", msg.Sender).Msg("Skipped commit")
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if id != "" && scheduler.onCommit != nil {
+				scheduler.onCommit(msg, id)
+			}
+
+			done(msg, nil)
 		}
 	}
 }
@@ -3,11 +3,18 @@ package signal
 import (
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 var Ch chan os.Signal
 
+// Hup receives SIGHUP, which makes running sessions reload their config
+var Hup chan os.Signal
+
 func Init() {
 	Ch = make(chan os.Signal, 1)
 	signal.Notify(Ch, os.Interrupt)
+
+	Hup = make(chan os.Signal, 1)
+	signal.Notify(Hup, syscall.SIGHUP)
 }
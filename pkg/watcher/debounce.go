@@ -0,0 +1,142 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// How many file names are listed in a commit message at most
+const maxListedFiles = 10
+
+// Debounce watches the files until ctx is done, gathering the changed ones and passing them to flush
+// once none changed for quiet (or once maxWait elapsed since the first change, 0 meaning no limit),
+// a quiet period of 0 flushes every change right away.
+// flush may return a channel holding the next flushes until it is closed, the files changing
+// meanwhile are kept for the next one
+func (w *Watcher) Debounce(ctx context.Context, quiet time.Duration, maxWait time.Duration, flush func(paths []string) <-chan struct{}) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.Watch(ctx)
+	}()
+
+	var changed []string
+	seen := make(map[string]bool)
+
+	var quietC, deadline <-chan time.Time
+	var quietTimer, deadlineTimer *time.Timer
+	var busy <-chan struct{}
+
+	stop := func() {
+		if quietTimer != nil {
+			quietTimer.Stop()
+		}
+		if deadlineTimer != nil {
+			deadlineTimer.Stop()
+		}
+		quietC, deadline = nil, nil
+	}
+	defer stop()
+
+	send := func() {
+		stop()
+
+		if len(changed) == 0 || busy != nil {
+			return
+		}
+
+		paths := changed
+		changed = nil
+		seen = make(map[string]bool)
+
+		busy = flush(paths)
+	}
+
+	wait := func() {
+		if quiet <= 0 {
+			send()
+			return
+		}
+
+		if quietTimer != nil {
+			quietTimer.Stop()
+		}
+		quietTimer = time.NewTimer(quiet)
+		quietC = quietTimer.C
+
+		if deadline == nil && maxWait > 0 {
+			deadlineTimer = time.NewTimer(maxWait)
+			deadline = deadlineTimer.C
+		}
+	}
+
+	// The watch and the flush going on are waited for, so that nothing runs once the event stopped
+	exit := func(err error) error {
+		if busy != nil {
+			<-busy
+		}
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return exit(<-errs)
+
+		case err := <-errs:
+			return exit(err)
+
+		case e := <-w.Events:
+			if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if !seen[e.Name] {
+				seen[e.Name] = true
+				changed = append(changed, e.Name)
+			}
+
+			wait()
+
+		case <-quietC:
+			send()
+
+		case <-deadline:
+			send()
+
+		case <-busy:
+			busy = nil
+
+			if len(changed) > 0 {
+				wait()
+			}
+
+		case err := <-w.Errors:
+			// Such as an overflow of the event queue on busy trees, watching goes on
+			log.Warn().Err(err).Msg("Watcher error, some changes may have been missed")
+		}
+	}
+}
+
+// ListFiles formats the list of changed files of a commit message, relative to root
+func ListFiles(root string, files []string) string {
+	rel := make([]string, 0, len(files))
+	for _, f := range files {
+		if r, err := filepath.Rel(root, f); err == nil && filepath.IsAbs(f) {
+			f = r
+		}
+		rel = append(rel, f)
+	}
+	files = rel
+
+	if len(files) <= maxListedFiles {
+		return strings.Join(files, ", ")
+	}
+
+	return fmt.Sprintf("%v and %v more", strings.Join(files[:maxListedFiles], ", "), len(files)-maxListedFiles)
+}
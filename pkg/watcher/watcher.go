@@ -0,0 +1,251 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"chrono/pkg/ignore"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// Watcher watches files and directory trees recursively, skipping ignored paths,
+// directories created later are watched as well
+type Watcher struct {
+	Events chan fsnotify.Event
+	Errors chan error
+
+	root    string
+	fs      *fsnotify.Watcher
+	ignore  *ignore.Matcher
+	watched map[string]bool
+	mutex   sync.Mutex
+}
+
+// New watches the given paths, root is the directory ignore rules are read from
+func New(root string, paths []string) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		Events:  make(chan fsnotify.Event),
+		Errors:  make(chan error),
+		root:    root,
+		fs:      fw,
+		watched: make(map[string]bool),
+	}
+
+	w.loadIgnore()
+
+	for _, p := range paths {
+		err = w.add(p)
+		if err != nil {
+			fw.Close()
+			return nil, fmt.Errorf("Couldn't add %v : %v", p, err.Error())
+		}
+	}
+
+	return w, nil
+}
+
+func (w *Watcher) loadIgnore() {
+	m := ignore.New(w.root)
+
+	filepath.WalkDir(w.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if d.IsDir() && m.Ignored(p, true) {
+			return filepath.SkipDir
+		}
+
+		// The ones at the root are already loaded by ignore.New
+		if filepath.Dir(p) != filepath.Clean(w.root) && isIgnoreFile(p) {
+			m.AddFile(p)
+		}
+
+		return nil
+	})
+
+	w.mutex.Lock()
+	w.ignore = m
+	w.mutex.Unlock()
+}
+
+func isIgnoreFile(p string) bool {
+	base := filepath.Base(p)
+	return base == ignore.GitIgnoreFileName || base == ignore.ChronoIgnoreFileName
+}
+
+func (w *Watcher) ignored(p string, isDir bool) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.ignore.Ignored(p, isDir)
+}
+
+// add watches a file, or a directory and all of its subdirectories
+func (w *Watcher) add(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return w.fs.Add(p)
+	}
+
+	return filepath.WalkDir(p, func(sub string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if sub == p {
+				return err
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if sub != p && w.ignored(sub, true) {
+			return filepath.SkipDir
+		}
+
+		err = w.fs.Add(sub)
+		if err != nil {
+			return err
+		}
+
+		w.mutex.Lock()
+		w.watched[filepath.Clean(sub)] = true
+		w.mutex.Unlock()
+
+		log.Debug().Str("dir", sub).Msg("Watching")
+		return nil
+	})
+}
+
+// remove stops watching a directory and its subdirectories
+func (w *Watcher) remove(p string) {
+	p = filepath.Clean(p)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for dir := range w.watched {
+		if dir == p || strings.HasPrefix(dir, p+string(filepath.Separator)) {
+			w.fs.Remove(dir)
+			delete(w.watched, dir)
+		}
+	}
+}
+
+func (w *Watcher) isWatchedDir(p string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.watched[filepath.Clean(p)]
+}
+
+// Watch forwards events of non ignored paths until ctx is done
+func (w *Watcher) Watch(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case e, ok := <-w.fs.Events:
+			if !ok {
+				return errors.New("Couldn't read watcher event")
+			}
+
+			w.handle(ctx, e)
+
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return errors.New("Couldn't read watcher error")
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case w.Errors <- err:
+			}
+		}
+	}
+}
+
+func (w *Watcher) handle(ctx context.Context, e fsnotify.Event) {
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && w.isWatchedDir(e.Name) {
+		w.remove(e.Name)
+		return
+	}
+
+	info, err := os.Stat(e.Name)
+	isDir := err == nil && info.IsDir()
+
+	if w.ignored(e.Name, isDir) {
+		return
+	}
+
+	if isIgnoreFile(e.Name) {
+		w.loadIgnore()
+	}
+
+	if isDir {
+		if e.Op&fsnotify.Create != 0 {
+			err = w.add(e.Name)
+			if err != nil {
+				log.Error().Err(err).Str("dir", e.Name).Msg("Couldn't watch new directory")
+			}
+
+			// Files may have been created in it before it was watched
+			w.emitFiles(ctx, e.Name)
+		}
+		return
+	}
+
+	w.emit(ctx, e)
+}
+
+func (w *Watcher) emitFiles(ctx context.Context, dir string) {
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if w.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			w.emit(ctx, fsnotify.Event{Name: p, Op: fsnotify.Create})
+		}
+
+		return nil
+	})
+}
+
+func (w *Watcher) emit(ctx context.Context, e fsnotify.Event) {
+	select {
+	case <-ctx.Done():
+	case w.Events <- e:
+	}
+}
+
+func (w *Watcher) Close() error {
+	return w.fs.Close()
+}